@@ -1,18 +1,23 @@
-import { BrowserRouter, Routes, Route, Navigate } from 'react-router-dom'
+import { BrowserRouter, Routes, Route, Navigate, useLocation } from 'react-router-dom'
 import { useAuthStore } from '@/store/auth'
 import LoginPage from '@/pages/LoginPage'
 import RegisterPage from '@/pages/RegisterPage'
 import DashboardPage from '@/pages/DashboardPage'
+import ConsentPage from '@/pages/ConsentPage'
+import MagicLinkPage from '@/pages/MagicLinkPage'
 
 function ProtectedRoute({ children }: { children: React.ReactNode }) {
   const token = useAuthStore((s) => s.accessToken)
-  if (!token) return <Navigate to="/login" replace />
+  const location = useLocation()
+  // Guarda a página pedida (ex.: consentimento OAuth) para voltar após o login
+  if (!token) return <Navigate to="/login" replace state={{ from: location.pathname + location.search }} />
   return <>{children}</>
 }
 
 function GuestRoute({ children }: { children: React.ReactNode }) {
   const token = useAuthStore((s) => s.accessToken)
-  if (token) return <Navigate to="/dashboard" replace />
+  const location = useLocation()
+  if (token) return <Navigate to={location.state?.from ?? '/dashboard'} replace />
   return <>{children}</>
 }
 
@@ -37,6 +42,14 @@ export default function App() {
             </GuestRoute>
           }
         />
+        <Route
+          path="/magic-link"
+          element={
+            <GuestRoute>
+              <MagicLinkPage />
+            </GuestRoute>
+          }
+        />
         <Route
           path="/dashboard"
           element={
@@ -45,6 +58,14 @@ export default function App() {
             </ProtectedRoute>
           }
         />
+        <Route
+          path="/oauth/consent"
+          element={
+            <ProtectedRoute>
+              <ConsentPage />
+            </ProtectedRoute>
+          }
+        />
       </Routes>
     </BrowserRouter>
   )
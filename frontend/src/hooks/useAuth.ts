@@ -1,5 +1,5 @@
 import { useMutation, useQueryClient } from '@tanstack/react-query'
-import { useNavigate } from 'react-router-dom'
+import { useLocation, useNavigate } from 'react-router-dom'
 import { datadogRum } from '@datadog/browser-rum'
 import { authService } from '@/services/api'
 import { useAuthStore } from '@/store/auth'
@@ -7,6 +7,7 @@ import { useAuthStore } from '@/store/auth'
 export function useLogin() {
   const setAuth = useAuthStore((s) => s.setAuth)
   const navigate = useNavigate()
+  const location = useLocation()
   const queryClient = useQueryClient()
 
   return useMutation({
@@ -18,7 +19,41 @@ export function useLogin() {
       queryClient.clear()
       setAuth(access_token, refresh_token, user)
       datadogRum.setUser({ id: user.id, email: user.email, name: user.name })
-      navigate('/dashboard')
+      navigate(location.state?.from ?? '/dashboard', { replace: true })
+    },
+  })
+}
+
+// O segredo de vínculo fica neste navegador: o link só funciona aqui
+const MAGIC_LINK_BINDING_KEY = 'dogpay-magic-link-binding'
+
+export function useRequestMagicLink() {
+  return useMutation({
+    mutationFn: (email: string) => authService.requestMagicLink(email),
+    onSuccess: (res) => {
+      localStorage.setItem(MAGIC_LINK_BINDING_KEY, res.data.binding_secret)
+    },
+  })
+}
+
+export function useVerifyMagicLink() {
+  const setAuth = useAuthStore((s) => s.setAuth)
+  const navigate = useNavigate()
+  const queryClient = useQueryClient()
+
+  return useMutation({
+    mutationFn: (token: string) =>
+      authService.verifyMagicLink({
+        token,
+        binding_secret: localStorage.getItem(MAGIC_LINK_BINDING_KEY) ?? '',
+      }),
+    onSuccess: (res) => {
+      localStorage.removeItem(MAGIC_LINK_BINDING_KEY)
+      const { access_token, refresh_token, user } = res.data
+      queryClient.clear()
+      setAuth(access_token, refresh_token, user)
+      datadogRum.setUser({ id: user.id, email: user.email, name: user.name })
+      navigate('/dashboard', { replace: true })
     },
   })
 }
@@ -47,6 +82,11 @@ export function useLogout() {
   const queryClient = useQueryClient()
 
   return () => {
+    // Revoga o refresh token no servidor; a sessão local é limpa mesmo se falhar
+    const refreshToken = useAuthStore.getState().refreshToken
+    if (refreshToken) {
+      authService.logout(refreshToken).catch(() => {})
+    }
     // Limpa todo o cache antes de deslogar
     queryClient.clear()
     datadogRum.clearUser()
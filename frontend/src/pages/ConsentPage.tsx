@@ -0,0 +1,98 @@
+import { useSearchParams } from 'react-router-dom'
+import { useMutation, useQuery } from '@tanstack/react-query'
+import { authService } from '@/services/api'
+
+const SCOPE_LABELS: Record<string, string> = {
+  openid: 'Saber quem você é',
+  profile: 'Ver seu nome',
+  email: 'Ver seu email',
+  offline_access: 'Continuar conectado quando você não estiver usando o app',
+  'balance:read': 'Ver seu saldo',
+  'history:read': 'Ver seu histórico de transações',
+  'transfer:write': 'Fazer transferências em seu nome',
+}
+
+export default function ConsentPage() {
+  const [params] = useSearchParams()
+  const requestId = params.get('request_id') ?? ''
+
+  const consent = useQuery({
+    queryKey: ['oauth-consent', requestId],
+    queryFn: () => authService.getConsent(requestId).then((res) => res.data),
+    enabled: requestId !== '',
+    retry: false,
+  })
+
+  // O servidor devolve a URL do app parceiro, com o código ou o erro
+  const decide = useMutation({
+    mutationFn: (approve: boolean) => authService.decideConsent(requestId, approve),
+    onSuccess: (res) => {
+      window.location.assign(res.data.redirect_to)
+    },
+  })
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gradient-to-br from-dd-50 to-dd-100">
+      <div className="w-full max-w-md">
+        <div className="bg-white rounded-2xl shadow-xl p-8">
+          <div className="text-center mb-6">
+            <div className="text-4xl mb-2">🐾</div>
+            <h1 className="text-2xl font-bold text-gray-900">DogPay</h1>
+          </div>
+
+          {consent.isLoading && <p className="text-center text-gray-500">Carregando...</p>}
+
+          {(consent.isError || requestId === '') && (
+            <div className="bg-red-50 border border-red-200 text-red-700 px-4 py-3 rounded-lg text-sm">
+              Pedido de autorização inválido ou expirado. Volte ao aplicativo e tente novamente.
+            </div>
+          )}
+
+          {consent.data && (
+            <>
+              <p className="text-gray-700 mb-4">
+                <span className="font-semibold">{consent.data.client_name}</span> quer acessar sua
+                conta DogPay para:
+              </p>
+              <ul className="space-y-2 mb-6">
+                {consent.data.scopes.map((scope: string) => (
+                  <li key={scope} className="flex items-start gap-2 text-sm text-gray-700">
+                    <span className="text-dd-600">✓</span>
+                    {SCOPE_LABELS[scope] ?? scope}
+                  </li>
+                ))}
+              </ul>
+              <p className="text-xs text-gray-500 mb-6">
+                Sua senha não é compartilhada. Você pode desconectar o aplicativo a qualquer momento
+                nas suas sessões.
+              </p>
+
+              {decide.error && (
+                <div className="bg-red-50 border border-red-200 text-red-700 px-4 py-3 rounded-lg text-sm mb-4">
+                  Não foi possível registrar sua resposta. Tente novamente.
+                </div>
+              )}
+
+              <div className="flex gap-3">
+                <button
+                  onClick={() => decide.mutate(false)}
+                  disabled={decide.isPending}
+                  className="flex-1 border border-gray-300 text-gray-700 py-2.5 rounded-lg font-medium hover:bg-gray-50 disabled:opacity-50 disabled:cursor-not-allowed transition"
+                >
+                  Negar
+                </button>
+                <button
+                  onClick={() => decide.mutate(true)}
+                  disabled={decide.isPending}
+                  className="flex-1 bg-dd-600 text-white py-2.5 rounded-lg font-medium hover:bg-dd-700 focus:outline-none focus:ring-2 focus:ring-dd-500 focus:ring-offset-2 disabled:opacity-50 disabled:cursor-not-allowed transition"
+                >
+                  Permitir
+                </button>
+              </div>
+            </>
+          )}
+        </div>
+      </div>
+    </div>
+  )
+}
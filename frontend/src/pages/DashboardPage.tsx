@@ -194,6 +194,8 @@ export default function DashboardPage() {
                   id: string
                   from_account_id: string | null
                   to_account_id: string
+                  from_name: string | null
+                  to_name: string
                   amount: number
                   status: string
                   description: string | null
@@ -219,7 +221,12 @@ export default function DashboardPage() {
                         </div>
                         <div>
                           <p className="text-sm font-medium text-gray-900">
-                            {tx.description || (isOutgoing ? 'Transferência enviada' : 'Transferência recebida')}
+                            {tx.description ||
+                              (isOutgoing
+                                ? `Enviada para ${tx.to_name}`
+                                : tx.from_name
+                                ? `Recebida de ${tx.from_name}`
+                                : 'Transferência recebida')}
                           </p>
                           <p className="text-xs text-gray-400">
                             {formatDate(tx.created_at)}
@@ -1,11 +1,12 @@
 import { useState } from 'react'
 import { Link } from 'react-router-dom'
-import { useLogin } from '@/hooks/useAuth'
+import { useLogin, useRequestMagicLink } from '@/hooks/useAuth'
 
 export default function LoginPage() {
   const [email, setEmail] = useState('')
   const [password, setPassword] = useState('')
   const login = useLogin()
+  const magicLink = useRequestMagicLink()
 
   const handleSubmit = (e: React.FormEvent) => {
     e.preventDefault()
@@ -67,6 +68,21 @@ export default function LoginPage() {
             </button>
           </form>
 
+          <button
+            type="button"
+            onClick={() => email && magicLink.mutate(email)}
+            disabled={!email || magicLink.isPending}
+            className="w-full mt-3 border border-gray-300 text-gray-700 py-2.5 rounded-lg font-medium hover:bg-gray-50 disabled:opacity-50 disabled:cursor-not-allowed transition"
+          >
+            Receber link de acesso por email
+          </button>
+
+          {magicLink.isSuccess && (
+            <div className="bg-green-50 border border-green-200 text-green-700 px-4 py-3 rounded-lg text-sm mt-3">
+              Se o email estiver cadastrado, enviamos um link de acesso. Abra-o neste navegador.
+            </div>
+          )}
+
           <p className="text-center text-sm text-gray-600 mt-6">
             Não tem conta?{' '}
             <Link to="/register" className="text-dd-600 hover:text-dd-700 font-medium">
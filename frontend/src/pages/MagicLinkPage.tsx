@@ -0,0 +1,46 @@
+import { useEffect, useRef } from 'react'
+import { Link, useSearchParams } from 'react-router-dom'
+import { useVerifyMagicLink } from '@/hooks/useAuth'
+
+export default function MagicLinkPage() {
+  const [params] = useSearchParams()
+  const token = params.get('token') ?? ''
+  const verify = useVerifyMagicLink()
+  const started = useRef(false)
+
+  // O link vale uma vez só: o StrictMode roda o efeito duas vezes em dev
+  useEffect(() => {
+    if (!token || started.current) return
+    started.current = true
+    verify.mutate(token)
+  }, [token, verify])
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gradient-to-br from-dd-50 to-dd-100">
+      <div className="w-full max-w-md">
+        <div className="bg-white rounded-2xl shadow-xl p-8">
+          <div className="text-center mb-6">
+            <div className="text-4xl mb-2">🐾</div>
+            <h1 className="text-2xl font-bold text-gray-900">DogPay</h1>
+          </div>
+
+          {verify.isPending && <p className="text-center text-gray-500">Entrando...</p>}
+
+          {(verify.isError || token === '') && (
+            <>
+              <div className="bg-red-50 border border-red-200 text-red-700 px-4 py-3 rounded-lg text-sm">
+                Link de acesso inválido ou expirado. Ele só funciona uma vez e no navegador em que
+                foi pedido.
+              </div>
+              <p className="text-center text-sm text-gray-600 mt-6">
+                <Link to="/login" className="text-dd-600 hover:text-dd-700 font-medium">
+                  Voltar para o login
+                </Link>
+              </p>
+            </>
+          )}
+        </div>
+      </div>
+    </div>
+  )
+}
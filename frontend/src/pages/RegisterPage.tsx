@@ -70,7 +70,8 @@ export default function RegisterPage() {
 
             {register.error && (
               <div className="bg-red-50 border border-red-200 text-red-700 px-4 py-3 rounded-lg text-sm">
-                {(register.error as { response?: { data?: { error?: string } } })?.response?.data?.error ||
+                {(register.error as { response?: { data?: { fields?: { password?: string[] } } } })?.response?.data?.fields?.password?.join('; ') ||
+                  (register.error as { response?: { data?: { error?: string } } })?.response?.data?.error ||
                   'Erro ao criar conta. Tente outro email.'}
               </div>
             )}
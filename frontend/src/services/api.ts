@@ -1,13 +1,14 @@
-import axios from 'axios'
+import axios, { type AxiosError, type AxiosResponse } from 'axios'
 import { datadogLogs } from '@datadog/browser-logs'
 import { useAuthStore } from '@/store/auth'
+import { createProof, dpopAvailable, isNonceError, rememberNonce } from '@/services/dpop'
 
 const AUTH_URL = import.meta.env.VITE_AUTH_API_URL || 'http://localhost:8001'
 const PAYMENT_URL = import.meta.env.VITE_PAYMENT_API_URL || 'http://localhost:8002'
 
 export const authApi = axios.create({
   baseURL: AUTH_URL,
-  headers: { 'Content-Type': 'application/json' },
+  headers: { 'Content-Type': 'application/json', 'X-Client-Type': 'web' },
 })
 
 export const paymentApi = axios.create({
@@ -15,32 +16,66 @@ export const paymentApi = axios.create({
   headers: { 'Content-Type': 'application/json' },
 })
 
-// Inject JWT token into requests
+// Sign every request with a DPoP proof; tokens bound to the browser key go
+// with the DPoP scheme. Without WebCrypto the tokens stay plain Bearer.
+async function withProof(method: string, url: string, headers: Record<string, string>, token?: string | null) {
+  if (!dpopAvailable) {
+    if (token) headers.Authorization = `Bearer ${token}`
+    return
+  }
+  headers.DPoP = await createProof(method, url, token)
+  if (token) headers.Authorization = `DPoP ${token}`
+}
+
+async function refreshSession(refreshToken: string, retried = false): Promise<AxiosResponse> {
+  const url = `${AUTH_URL}/auth/refresh`
+  const headers: Record<string, string> = { 'X-Client-Type': 'web' }
+  await withProof('POST', url, headers)
+  try {
+    const res = await axios.post(url, { refresh_token: refreshToken }, { headers })
+    rememberNonce(url, res.headers['dpop-nonce'])
+    return res
+  } catch (error) {
+    const response = (error as AxiosError).response
+    rememberNonce(url, response?.headers['dpop-nonce'])
+    if (!retried && isNonceError(response?.data)) return refreshSession(refreshToken, true)
+    throw error
+  }
+}
+
 function addAuthInterceptor(api: typeof authApi) {
-  api.interceptors.request.use((config) => {
-    const token = useAuthStore.getState().accessToken
-    if (token) {
-      config.headers.Authorization = `Bearer ${token}`
-    }
+  api.interceptors.request.use(async (config) => {
+    const url = api.getUri(config)
+    const headers: Record<string, string> = {}
+    await withProof(config.method ?? 'get', url, headers, useAuthStore.getState().accessToken)
+    Object.entries(headers).forEach(([name, value]) => config.headers.set(name, value))
     return config
   })
 
-  // Handle 401 - try to refresh token
   api.interceptors.response.use(
-    (res) => res,
+    (res) => {
+      rememberNonce(api.getUri(res.config), res.headers['dpop-nonce'])
+      return res
+    },
     async (error) => {
       const original = error.config
+      if (original && error.response) {
+        rememberNonce(api.getUri(original), error.response.headers['dpop-nonce'])
+      }
+      // Retry once with the nonce the server just sent
+      if (isNonceError(error.response?.data) && !original._nonceRetry) {
+        original._nonceRetry = true
+        return api(original)
+      }
+      // Handle 401 - try to refresh token
       if (error.response?.status === 401 && !original._retry) {
         original._retry = true
         const refreshToken = useAuthStore.getState().refreshToken
         if (refreshToken) {
           try {
-            const res = await axios.post(`${AUTH_URL}/auth/refresh`, {
-              refresh_token: refreshToken,
-            })
+            const res = await refreshSession(refreshToken)
             const { access_token, refresh_token, user } = res.data
             useAuthStore.getState().setAuth(access_token, refresh_token, user)
-            original.headers.Authorization = `Bearer ${access_token}`
             return api(original)
           } catch {
             useAuthStore.getState().logout()
@@ -68,9 +103,33 @@ export const authService = {
     authApi.post('/auth/register', data),
   login: (data: { email: string; password: string }) =>
     authApi.post('/auth/login', data),
+  requestMagicLink: (email: string) => authApi.post('/auth/magic-link', { email }),
+  verifyMagicLink: (data: { token: string; binding_secret: string }) =>
+    authApi.post('/auth/magic-link/verify', data),
   me: () => authApi.get('/auth/me'),
+  updateProfile: (data: { name: string }) => authApi.patch('/auth/me', data),
+  changePassword: (data: { current_password: string; new_password: string }) =>
+    authApi.post('/auth/password/change', data),
+  requestEmailChange: (data: { new_email: string; password: string }) =>
+    authApi.post('/auth/email/change', data),
   refresh: (refreshToken: string) =>
     authApi.post('/auth/refresh', { refresh_token: refreshToken }),
+  logout: (refreshToken: string) =>
+    authApi.post('/auth/logout', { refresh_token: refreshToken }),
+  closeAccount: (data: { password: string; code?: string; sweep_to_email?: string }) =>
+    authApi.post('/auth/me/close', data),
+  exportData: () => authApi.get('/auth/me/export', { responseType: 'blob' }),
+  getActivity: (params?: { event_type?: string; before?: number; limit?: number }) =>
+    authApi.get('/auth/me/activity', { params }),
+  listTokens: () => authApi.get('/auth/tokens'),
+  createToken: (data: { name: string; scopes: string[]; expires_in_days?: number }) =>
+    authApi.post('/auth/tokens', data),
+  revokeToken: (id: string) => authApi.delete(`/auth/tokens/${id}`),
+  listSessions: () => authApi.get('/auth/sessions'),
+  revokeSession: (id: string) => authApi.delete(`/auth/sessions/${id}`),
+  getConsent: (requestId: string) => authApi.get(`/oauth/consent/${requestId}`),
+  decideConsent: (requestId: string, approve: boolean) =>
+    authApi.post(`/oauth/consent/${requestId}`, { approve }),
 }
 
 // Payment API calls
@@ -0,0 +1,89 @@
+// Provas DPoP (RFC 9449). O par de chaves ECDSA P-256 é gerado como não
+// exportável e guardado no IndexedDB. O access token só é aceito com uma
+// prova dessa chave, e o refresh token só é renovado com ela e nunca vale
+// como access token: quem copiar os tokens do localStorage não consegue
+// usá-los sem a chave privada.
+const DB_NAME = 'dogpay-dpop'
+const STORE = 'keys'
+
+export const dpopAvailable =
+  typeof indexedDB !== 'undefined' && typeof crypto !== 'undefined' && !!crypto.subtle
+
+let keyPair: Promise<CryptoKeyPair> | null = null
+// Cada serviço emite seus próprios nonces
+const nonces = new Map<string, string>()
+
+function request<T>(req: IDBRequest<T>): Promise<T> {
+  return new Promise((resolve, reject) => {
+    req.onsuccess = () => resolve(req.result)
+    req.onerror = () => reject(req.error)
+  })
+}
+
+async function loadKeyPair(): Promise<CryptoKeyPair> {
+  const open = indexedDB.open(DB_NAME, 1)
+  open.onupgradeneeded = () => open.result.createObjectStore(STORE)
+  const db = await request(open)
+
+  const stored = await request<CryptoKeyPair | undefined>(
+    db.transaction(STORE).objectStore(STORE).get('current'),
+  )
+  if (stored) return stored
+
+  const pair = await crypto.subtle.generateKey({ name: 'ECDSA', namedCurve: 'P-256' }, false, [
+    'sign',
+    'verify',
+  ])
+  await request(db.transaction(STORE, 'readwrite').objectStore(STORE).put(pair, 'current'))
+  return pair
+}
+
+function base64url(bytes: ArrayBuffer | Uint8Array): string {
+  return btoa(String.fromCharCode(...new Uint8Array(bytes)))
+    .replace(/\+/g, '-')
+    .replace(/\//g, '_')
+    .replace(/=+$/, '')
+}
+
+function encode(value: unknown): string {
+  return base64url(new TextEncoder().encode(JSON.stringify(value)))
+}
+
+// Gera a prova para uma requisição; com access token, a prova leva o hash dele
+export async function createProof(method: string, url: string, accessToken?: string | null) {
+  keyPair ??= loadKeyPair()
+  const { privateKey, publicKey } = await keyPair
+  const { kty, crv, x, y } = await crypto.subtle.exportKey('jwk', publicKey)
+
+  const target = new URL(url)
+  const payload: Record<string, unknown> = {
+    jti: crypto.randomUUID(),
+    htm: method.toUpperCase(),
+    htu: target.origin + target.pathname,
+    iat: Math.floor(Date.now() / 1000),
+  }
+  const nonce = nonces.get(target.origin)
+  if (nonce) payload.nonce = nonce
+  if (accessToken) {
+    payload.ath = base64url(
+      await crypto.subtle.digest('SHA-256', new TextEncoder().encode(accessToken)),
+    )
+  }
+
+  const input = `${encode({ typ: 'dpop+jwt', alg: 'ES256', jwk: { kty, crv, x, y } })}.${encode(payload)}`
+  const signature = await crypto.subtle.sign(
+    { name: 'ECDSA', hash: 'SHA-256' },
+    privateKey,
+    new TextEncoder().encode(input),
+  )
+  return `${input}.${base64url(signature)}`
+}
+
+export function rememberNonce(url: string, nonce: string | undefined) {
+  if (nonce) nonces.set(new URL(url).origin, nonce)
+}
+
+// O servidor pede a prova de novo com o nonce que acabou de enviar
+export function isNonceError(data: unknown) {
+  return (data as { error?: string } | undefined)?.error === 'use_dpop_nonce'
+}
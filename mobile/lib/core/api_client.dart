@@ -1,3 +1,5 @@
+import 'dart:io';
+
 import 'package:dio/dio.dart';
 import 'package:flutter_secure_storage/flutter_secure_storage.dart';
 
@@ -7,15 +9,21 @@ const String _paymentBaseUrl = 'http://localhost:8002';
 
 final _storage = FlutterSecureStorage();
 
-Dio createAuthDio() => _buildDio(_authBaseUrl);
-Dio createPaymentDio() => _buildDio(_paymentBaseUrl);
+// Lets the auth service label sessions, e.g. "mobile · ios 18.1"
+final Map<String, String> _clientHeaders = {
+  'X-Client-Type': 'mobile',
+  'User-Agent': 'DogPay/1.0 (${Platform.operatingSystem} ${Platform.operatingSystemVersion})',
+};
+
+Dio createAuthDio() => _buildDio(_authBaseUrl, _clientHeaders);
+Dio createPaymentDio() => _buildDio(_paymentBaseUrl, const {});
 
-Dio _buildDio(String baseUrl) {
+Dio _buildDio(String baseUrl, Map<String, String> extraHeaders) {
   final dio = Dio(BaseOptions(
     baseUrl: baseUrl,
     connectTimeout: const Duration(seconds: 10),
     receiveTimeout: const Duration(seconds: 10),
-    headers: {'Content-Type': 'application/json'},
+    headers: {'Content-Type': 'application/json', ...extraHeaders},
   ));
 
   // Inject JWT
@@ -55,7 +63,7 @@ Future<bool> _tryRefreshToken() async {
   if (refreshToken == null) return false;
 
   try {
-    final dio = Dio(BaseOptions(baseUrl: _authBaseUrl));
+    final dio = Dio(BaseOptions(baseUrl: _authBaseUrl, headers: _clientHeaders));
     final res = await dio.post('/auth/refresh', data: {'refresh_token': refreshToken});
     await _storage.write(key: 'access_token',  value: res.data['access_token']);
     await _storage.write(key: 'refresh_token', value: res.data['refresh_token']);
@@ -2,6 +2,8 @@ class Transaction {
   final String id;
   final String? fromAccountId;
   final String toAccountId;
+  final String? fromName;
+  final String toName;
   final double amount;
   final String status;
   final String? description;
@@ -11,6 +13,8 @@ class Transaction {
     required this.id,
     this.fromAccountId,
     required this.toAccountId,
+    this.fromName,
+    required this.toName,
     required this.amount,
     required this.status,
     this.description,
@@ -21,6 +25,8 @@ class Transaction {
         id: json['id'],
         fromAccountId: json['from_account_id'],
         toAccountId: json['to_account_id'],
+        fromName: json['from_name'],
+        toName: json['to_name'] ?? '',
         amount: (json['amount'] as num).toDouble(),
         status: json['status'],
         description: json['description'],
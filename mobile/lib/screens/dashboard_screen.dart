@@ -437,7 +437,9 @@ class _TransactionTile extends StatelessWidget {
     final icon       = isOutgoing ? Icons.arrow_upward : Icons.arrow_downward;
     final label      = tx.description?.isNotEmpty == true
         ? tx.description!
-        : isOutgoing ? 'Transferência enviada' : 'Transferência recebida';
+        : isOutgoing
+            ? 'Enviada para ${tx.toName}'
+            : tx.fromName != null ? 'Recebida de ${tx.fromName}' : 'Transferência recebida';
 
     String statusLabel;
     Color  statusColor;
@@ -16,6 +16,23 @@ class AuthService {
     return User.fromJson(res.data['user']);
   }
 
+  // The binding secret stays on this device; the emailed link only works here
+  Future<void> requestMagicLink(String email) async {
+    final res = await _dio.post('/auth/magic-link', data: {'email': email});
+    await _storage.write(key: 'magic_link_binding', value: res.data['binding_secret']);
+  }
+
+  Future<User> verifyMagicLink(String token) async {
+    final binding = await _storage.read(key: 'magic_link_binding');
+    final res = await _dio.post('/auth/magic-link/verify', data: {
+      'token': token,
+      'binding_secret': binding ?? '',
+    });
+    await _storage.delete(key: 'magic_link_binding');
+    await _saveTokens(res.data);
+    return User.fromJson(res.data['user']);
+  }
+
   Future<User> register(String name, String email, String password) async {
     final res = await _dio.post('/auth/register', data: {
       'name': name,
@@ -27,9 +44,38 @@ class AuthService {
   }
 
   Future<void> logout() async {
+    final refreshToken = await _storage.read(key: 'refresh_token');
+    if (refreshToken != null) {
+      try {
+        await _dio.post('/auth/logout', data: {'refresh_token': refreshToken});
+      } catch (_) {
+        // Local logout proceeds even if the server is unreachable
+      }
+    }
     await _storage.deleteAll();
   }
 
+  Future<User> updateProfile(String name) async {
+    final res = await _dio.patch('/auth/me', data: {'name': name});
+    return User.fromJson(res.data);
+  }
+
+  Future<void> changePassword(String currentPassword, String newPassword) async {
+    await _dio.post('/auth/password/change', data: {
+      'current_password': currentPassword,
+      'new_password': newPassword,
+    });
+  }
+
+  Future<List<Map<String, dynamic>>> listSessions() async {
+    final res = await _dio.get('/auth/sessions');
+    return List<Map<String, dynamic>>.from(res.data);
+  }
+
+  Future<void> revokeSession(String id) async {
+    await _dio.delete('/auth/sessions/$id');
+  }
+
   Future<bool> isLoggedIn() async {
     final token = await _storage.read(key: 'access_token');
     return token != null;
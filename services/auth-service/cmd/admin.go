@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dogpay/auth-service/internal/handlers"
+	"github.com/dogpay/auth-service/internal/keys"
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/dogpay/auth-service/internal/repository"
+)
+
+const adminUsage = `usage:
+  auth-service keys list
+  auth-service keys rotate [-activate-in 10m]
+  auth-service users unlock <email>
+  auth-service users grant-role <email> <role>
+  auth-service users revoke-role <email> <role>
+  auth-service roles list
+  auth-service roles create [-description text] <name> <permission,...>
+  auth-service oauth-clients create [-public] -redirect-uri <uri,...> -scopes <scope,...> <name>
+  auth-service oauth-clients create -service -scopes <scope,...> <name>
+  auth-service outbox failed
+  auth-service outbox retry <event-id>`
+
+func runAdminCommand(ctx context.Context, keyring *keys.Keyring, users *repository.UserRepository, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf(adminUsage)
+	}
+
+	switch args[0] + " " + args[1] {
+	case "keys list":
+		return listKeys(ctx, keyring)
+	case "keys rotate":
+		return rotateKeys(ctx, keyring, args[2:])
+	case "users unlock":
+		return unlockUser(ctx, users, args[2:])
+	case "users grant-role":
+		return changeUserRole(ctx, users, args[2:], true)
+	case "users revoke-role":
+		return changeUserRole(ctx, users, args[2:], false)
+	case "roles list":
+		return listRoles(ctx, users)
+	case "roles create":
+		return createRole(ctx, users, args[2:])
+	case "oauth-clients create":
+		return createOAuthClient(ctx, users, args[2:])
+	case "outbox failed":
+		return listFailedOutboxEvents(ctx, users)
+	case "outbox retry":
+		return requeueOutboxEvent(ctx, users, args[2:])
+	default:
+		return fmt.Errorf(adminUsage)
+	}
+}
+
+func listKeys(ctx context.Context, keyring *keys.Keyring) error {
+	stored, err := keyring.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KID\tSTATUS\tACTIVATES AT\tRETIRING AT\tRETIRED AT")
+	for _, k := range stored {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", k.KID, k.Status,
+			k.ActivatesAt.Format(time.RFC3339), formatTime(k.RetiringAt), formatTime(k.RetiredAt))
+	}
+	return w.Flush()
+}
+
+func rotateKeys(ctx context.Context, keyring *keys.Keyring, args []string) error {
+	fs := flag.NewFlagSet("keys rotate", flag.ContinueOnError)
+	// Verifiers cache the JWKS for up to 5 minutes and auth-service instances
+	// reload the keyring every 30 seconds, so the new key must be published
+	// for longer than that before anything is signed with it.
+	activateIn := fs.Duration("activate-in", 10*time.Minute, "delay before the new key starts signing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *activateIn < 6*time.Minute {
+		fmt.Fprintln(os.Stderr, "warning: verifiers may reject tokens signed before they refresh the JWKS")
+	}
+
+	key, err := keyring.Rotate(ctx, *activateIn)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created key %s, signing from %s\n", key.KID, key.ActivatesAt.Format(time.RFC3339))
+	return nil
+}
+
+func unlockUser(ctx context.Context, users *repository.UserRepository, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf(adminUsage)
+	}
+
+	user, err := users.FindByEmail(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if err := users.UnlockUser(ctx, user.ID); err != nil {
+		return err
+	}
+	fmt.Printf("unlocked %s\n", user.Email)
+	return nil
+}
+
+func changeUserRole(ctx context.Context, users *repository.UserRepository, args []string, grant bool) error {
+	if len(args) != 2 {
+		return fmt.Errorf(adminUsage)
+	}
+
+	user, err := users.FindByEmail(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	role := args[1]
+	if grant {
+		if err := users.GrantRole(ctx, user.ID, role); err != nil {
+			return err
+		}
+		fmt.Printf("granted %s to %s\n", role, user.Email)
+	} else {
+		if err := users.RevokeRole(ctx, user.ID, role); err != nil {
+			return err
+		}
+		fmt.Printf("revoked %s from %s\n", role, user.Email)
+	}
+	fmt.Println("takes effect with the next access token, within 15 minutes")
+	return nil
+}
+
+func listRoles(ctx context.Context, users *repository.UserRepository) error {
+	roles, err := users.ListRoles(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tBUILTIN\tPERMISSIONS\tDESCRIPTION")
+	for _, r := range roles {
+		fmt.Fprintf(w, "%s\t%t\t%s\t%s\n", r.Name, r.Builtin, strings.Join(r.Permissions, ","), r.Description)
+	}
+	return w.Flush()
+}
+
+func createRole(ctx context.Context, users *repository.UserRepository, args []string) error {
+	fs := flag.NewFlagSet("roles create", flag.ContinueOnError)
+	description := fs.String("description", "", "what the role is for")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf(adminUsage)
+	}
+
+	name := fs.Arg(0)
+	var permissions []string
+	for _, p := range strings.Split(fs.Arg(1), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			permissions = append(permissions, p)
+		}
+	}
+
+	if err := users.CreateRole(ctx, name, *description, permissions); err != nil {
+		return err
+	}
+	fmt.Printf("role %s has permissions %s\n", name, strings.Join(permissions, ","))
+	return nil
+}
+
+func createOAuthClient(ctx context.Context, users *repository.UserRepository, args []string) error {
+	fs := flag.NewFlagSet("oauth-clients create", flag.ContinueOnError)
+	public := fs.Bool("public", false, "client cannot keep a secret, e.g. a mobile or browser app")
+	service := fs.Bool("service", false, "client is a service using the client-credentials grant")
+	redirectURIs := fs.String("redirect-uri", "", "comma-separated redirect URIs")
+	scopes := fs.String("scopes", "", "comma-separated scopes the client may request")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || (*redirectURIs == "" && !*service) || *scopes == "" {
+		return fmt.Errorf(adminUsage)
+	}
+
+	req := models.CreateOAuthClientRequest{
+		Name:    fs.Arg(0),
+		Scopes:  strings.Split(*scopes, ","),
+		Public:  *public,
+		Service: *service,
+	}
+	if *redirectURIs != "" {
+		req.RedirectURIs = strings.Split(*redirectURIs, ",")
+	}
+
+	client, secret, err := handlers.RegisterOAuthClient(ctx, users, req)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("client_id:     %s\n", client.ID)
+	if secret != "" {
+		fmt.Printf("client_secret: %s\n", secret)
+	}
+	return nil
+}
+
+func listFailedOutboxEvents(ctx context.Context, users *repository.UserRepository) error {
+	events, err := users.ListFailedOutboxEvents(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTYPE\tATTEMPTS\tFAILED AT\tERROR")
+	for _, e := range events {
+		lastError := "-"
+		if e.LastError != nil {
+			lastError = *e.LastError
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", e.ID, e.Type, e.Attempts, formatTime(e.FailedAt), lastError)
+	}
+	return w.Flush()
+}
+
+func requeueOutboxEvent(ctx context.Context, users *repository.UserRepository, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf(adminUsage)
+	}
+
+	requeued, err := users.RequeueOutboxEvent(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if !requeued {
+		return fmt.Errorf("no failed outbox event %s", args[0])
+	}
+	fmt.Printf("requeued %s, the running service delivers it within seconds\n", args[0])
+	return nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
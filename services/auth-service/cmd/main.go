@@ -5,9 +5,16 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dogpay/auth-service/internal/handlers"
+	"github.com/dogpay/auth-service/internal/keys"
+	"github.com/dogpay/auth-service/internal/mailer"
 	"github.com/dogpay/auth-service/internal/middleware"
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/dogpay/auth-service/internal/password"
 	"github.com/dogpay/auth-service/internal/repository"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -36,35 +43,140 @@ func main() {
 	}
 	log.Println("Connected to PostgreSQL")
 
-	jwtSecret := getEnv("AUTH_JWT_SECRET", "dev-secret")
+	// Superseded keys keep verifying for the access token lifetime plus skew
+	keyring := keys.NewKeyring(repository.NewKeyRepository(db), 20*time.Minute)
+	userRepo := repository.NewUserRepository(db)
+
+	// Admin commands, e.g. `auth-service keys rotate`
+	if len(os.Args) > 1 {
+		if err := runAdminCommand(context.Background(), keyring, userRepo, os.Args[1:]); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
+	if err := keyring.Bootstrap(context.Background(), os.Getenv("AUTH_JWT_PRIVATE_KEY_FILE")); err != nil {
+		log.Fatalf("failed to load signing keys: %v", err)
+	}
+	go keyring.Run(context.Background(), 30*time.Second)
+	issuer := getEnv("AUTH_ISSUER", "http://localhost:8001")
+	// DPoP proofs name the public URL of the request, which is the issuer's
+	dpop := middleware.NewDPoPVerifier(issuer)
+	dpopProof := middleware.DPoPProof(dpop)
+	jwtAuth := middleware.JWTAuth(keyring, dpop, userRepo)
+	// Long-lived credentials are only minted right after a password or TOTP check
+	recentAuth := middleware.RequireRecentAuth(5*time.Minute, models.AMRPassword, models.AMROTP)
+
+	mail, err := newMailer()
+	if err != nil {
+		log.Fatalf("failed to set up mailer: %v", err)
+	}
+
+	go pruneExpired(userRepo)
 
 	// Setup dependencies
-	userRepo := repository.NewUserRepository(db)
-	authHandler := handlers.NewAuthHandler(userRepo, jwtSecret)
+	authHandler := handlers.NewAuthHandler(
+		userRepo,
+		keyring,
+		password.NewArgon2idHasher(passwordParams()),
+		passwordPolicy(),
+		mail,
+		getEnv("AUTH_APP_URL", "http://localhost:5173"),
+		issuer,
+	)
+
+	go authHandler.RunOutboxRelay(context.Background(), 5*time.Second)
 
 	// Gin router
 	r := gin.Default()
 
+	// Per-IP rate limits rely on ClientIP, so X-Forwarded-For is only
+	// honoured from proxies listed in AUTH_TRUSTED_PROXIES
+	var trustedProxies []string
+	if v := os.Getenv("AUTH_TRUSTED_PROXIES"); v != "" {
+		trustedProxies = strings.Split(v, ",")
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("invalid AUTH_TRUSTED_PROXIES: %v", err)
+	}
+
 	// CORS
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:5173", "http://localhost:80"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Client-Type", "DPoP"},
+		ExposeHeaders:    []string{"Content-Length", "Content-Disposition", "DPoP-Nonce", "WWW-Authenticate"},
 		AllowCredentials: true,
 	}))
 
+	r.Use(authHandler.Audit())
+
 	// Routes
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok", "service": "auth-service"})
 	})
 
+	r.GET("/.well-known/jwks.json", authHandler.JWKS)
+	r.GET("/.well-known/openid-configuration", authHandler.Discovery)
+
+	oauth := r.Group("/oauth")
+	{
+		oauth.GET("/authorize", authHandler.Authorize)
+		oauth.POST("/token", dpopProof, authHandler.Token)
+		oauth.GET("/userinfo", middleware.OAuthAuth(keyring, dpop, userRepo, "openid"), authHandler.UserInfo)
+		oauth.POST("/userinfo", middleware.OAuthAuth(keyring, dpop, userRepo, "openid"), authHandler.UserInfo)
+		oauth.GET("/consent/:id", jwtAuth, authHandler.GetConsent)
+		oauth.POST("/consent/:id", jwtAuth, authHandler.DecideConsent)
+		oauth.POST("/clients", jwtAuth, middleware.RequirePermission("clients:write"), authHandler.CreateOAuthClient)
+	}
+
 	auth := r.Group("/auth")
 	{
-		auth.POST("/register", authHandler.Register)
-		auth.POST("/login", authHandler.Login)
-		auth.POST("/refresh", authHandler.Refresh)
-		auth.GET("/me", middleware.JWTAuth(jwtSecret), authHandler.Me)
+		auth.POST("/register", dpopProof, authHandler.Register)
+		auth.POST("/login", dpopProof, authHandler.Login)
+		auth.POST("/magic-link", authHandler.RequestMagicLink)
+		auth.POST("/magic-link/verify", dpopProof, authHandler.VerifyMagicLink)
+		auth.POST("/refresh", dpopProof, authHandler.Refresh)
+		auth.POST("/logout", authHandler.Logout)
+		auth.POST("/introspect", authHandler.Introspect)
+		auth.POST("/password/forgot", authHandler.ForgotPassword)
+		auth.POST("/password/reset", authHandler.ResetPassword)
+		auth.POST("/verify-email", authHandler.VerifyEmail)
+		auth.POST("/verify-email/resend", jwtAuth, authHandler.ResendVerification)
+		auth.POST("/email/change", jwtAuth, authHandler.RequestEmailChange)
+		auth.POST("/email/change/confirm", authHandler.ConfirmEmailChange)
+		auth.POST("/email/change/cancel", authHandler.CancelEmailChange)
+		auth.POST("/mfa/verify", dpopProof, authHandler.VerifyMFA)
+		auth.POST("/mfa/totp/enroll", jwtAuth, authHandler.EnrollTOTP)
+		auth.POST("/mfa/totp/confirm", jwtAuth, authHandler.ConfirmTOTP)
+		auth.POST("/mfa/totp/disable", jwtAuth, authHandler.DisableTOTP)
+		auth.POST("/mfa/recovery-codes", jwtAuth, authHandler.RegenerateRecoveryCodes)
+		auth.POST("/logout-all", jwtAuth, authHandler.LogoutAll)
+		auth.GET("/sessions", jwtAuth, authHandler.ListSessions)
+		auth.DELETE("/sessions/:id", jwtAuth, authHandler.RevokeSession)
+		auth.GET("/tokens", jwtAuth, authHandler.ListPersonalAccessTokens)
+		auth.POST("/tokens", jwtAuth, recentAuth, authHandler.CreatePersonalAccessToken)
+		auth.DELETE("/tokens/:id", jwtAuth, authHandler.RevokePersonalAccessToken)
+		auth.GET("/me", jwtAuth, authHandler.Me)
+		auth.PATCH("/me", jwtAuth, authHandler.UpdateProfile)
+		auth.POST("/me/close", jwtAuth, authHandler.CloseAccount)
+		auth.GET("/me/export", jwtAuth, authHandler.ExportData)
+		auth.GET("/me/activity", jwtAuth, authHandler.Activity)
+		auth.POST("/password/change", jwtAuth, authHandler.ChangePassword)
+		auth.POST("/step-up", jwtAuth, authHandler.StepUp)
+	}
+
+	admin := r.Group("/admin", jwtAuth)
+	{
+		admin.GET("/audit-events", middleware.RequirePermission("audit:read"), authHandler.SearchAuditEvents)
+		admin.GET("/users", middleware.RequirePermission("users:read"), authHandler.SearchUsers)
+		admin.GET("/users/:id", middleware.RequirePermission("users:read"), authHandler.GetUser)
+		admin.GET("/users/:id/sessions", middleware.RequirePermission("users:read"), authHandler.ListUserSessions)
+		admin.GET("/users/:id/audit-events", middleware.RequirePermission("audit:read"), authHandler.ListUserAuditEvents)
+		admin.POST("/users/:id/disable", middleware.RequirePermission("users:write"), authHandler.DisableUser)
+		admin.POST("/users/:id/enable", middleware.RequirePermission("users:write"), authHandler.EnableUser)
+		admin.POST("/users/:id/logout", middleware.RequirePermission("users:write"), authHandler.ForceLogout)
+		admin.POST("/users/:id/unlock", middleware.RequirePermission("users:write"), authHandler.UnlockUser)
 	}
 
 	port := getEnv("AUTH_PORT", "8001")
@@ -74,6 +186,79 @@ func main() {
 	}
 }
 
+// pruneExpired drops rate limit windows, OAuth authorization requests,
+// expired refresh tokens, revocations of expired tokens and delivered outbox
+// events that can no longer matter.
+func pruneExpired(repo *repository.UserRepository) {
+	for range time.Tick(10 * time.Minute) {
+		if err := repo.PruneRateLimits(context.Background(), time.Now().Add(-time.Hour)); err != nil {
+			log.Printf("failed to prune rate limits: %v", err)
+		}
+		if err := repo.PruneOAuthRequests(context.Background()); err != nil {
+			log.Printf("failed to prune oauth requests: %v", err)
+		}
+		if err := repo.PruneRefreshTokens(context.Background()); err != nil {
+			log.Printf("failed to prune refresh tokens: %v", err)
+		}
+		if err := repo.PruneRevokedTokens(context.Background()); err != nil {
+			log.Printf("failed to prune revoked tokens: %v", err)
+		}
+		if err := repo.PruneOutboxEvents(context.Background(), time.Now().Add(-7*24*time.Hour)); err != nil {
+			log.Printf("failed to prune outbox events: %v", err)
+		}
+	}
+}
+
+// passwordParams reads the Argon2id cost from AUTH_ARGON2_*. Raising them
+// makes existing hashes get rehashed on the next successful login.
+func passwordParams() password.Argon2idParams {
+	params := password.DefaultParams
+	if v, err := strconv.ParseUint(getEnv("AUTH_ARGON2_MEMORY_KB", "0"), 10, 32); err == nil && v > 0 {
+		params.Memory = uint32(v)
+	}
+	if v, err := strconv.ParseUint(getEnv("AUTH_ARGON2_ITERATIONS", "0"), 10, 32); err == nil && v > 0 {
+		params.Iterations = uint32(v)
+	}
+	if v, err := strconv.ParseUint(getEnv("AUTH_ARGON2_PARALLELISM", "0"), 10, 8); err == nil && v > 0 {
+		params.Parallelism = uint8(v)
+	}
+	return params
+}
+
+// passwordPolicy applies AUTH_PASSWORD_MIN_LENGTH and AUTH_PASSWORD_BANNED
+// (comma-separated words) on top of the defaults. The breached password
+// check uses the corpus in AUTH_BREACHED_PASSWORDS_DIR, falling back to the
+// small sample built into the binary.
+func passwordPolicy() *password.Policy {
+	policy := password.DefaultPolicy()
+	if v, err := strconv.Atoi(getEnv("AUTH_PASSWORD_MIN_LENGTH", "0")); err == nil && v > 0 {
+		policy.MinLength = v
+	}
+	if banned := os.Getenv("AUTH_PASSWORD_BANNED"); banned != "" {
+		policy.BannedPatterns = append(policy.BannedPatterns, password.BannedWords(strings.Split(banned, ",")...)...)
+	}
+	if dir := os.Getenv("AUTH_BREACHED_PASSWORDS_DIR"); dir != "" {
+		policy.Breached = password.NewCorpus(os.DirFS(dir))
+	}
+	return policy
+}
+
+// newMailer picks the mail transport from AUTH_MAILER: "smtp" sends through
+// AUTH_SMTP_*, anything else writes messages to AUTH_MAIL_OUTBOX_DIR.
+func newMailer() (mailer.Mailer, error) {
+	from := getEnv("AUTH_MAIL_FROM", "DogPay <no-reply@dogpay.com>")
+	if getEnv("AUTH_MAILER", "outbox") == "smtp" {
+		return mailer.NewSMTPMailer(
+			getEnv("AUTH_SMTP_HOST", "localhost"),
+			getEnv("AUTH_SMTP_PORT", "587"),
+			os.Getenv("AUTH_SMTP_USERNAME"),
+			os.Getenv("AUTH_SMTP_PASSWORD"),
+			from,
+		), nil
+	}
+	return mailer.NewOutboxMailer(getEnv("AUTH_MAIL_OUTBOX_DIR", "outbox"), from)
+}
+
 func getEnv(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
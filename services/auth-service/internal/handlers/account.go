@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// CloseAccount closes the payment account of the current user, then
+// anonymizes the user and signs out every session. Transactions stay in
+// payment service, which is required to retain them.
+func (h *AuthHandler) CloseAccount(c *gin.Context) {
+	var req models.CloseAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.repo.FindByID(c.Request.Context(), c.GetString("user_id"))
+	if err != nil || user.ClosedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if h.refuseLocked(c, user) {
+		return
+	}
+
+	if !h.checkPassword(c, user, req.Password) {
+		auditDetail(c, "wrong_password", true)
+		h.recordLoginFailure(c, user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		ok, err := h.verifySecondFactor(c, user, req.Code, "")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify code"})
+			return
+		}
+		if !ok {
+			auditDetail(c, "wrong_code", true)
+			h.recordLoginFailure(c, user)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+			return
+		}
+	}
+	h.clearLoginFailures(c, user)
+
+	// Payment service refuses while money is left or transfers are pending,
+	// so it goes first; closing twice is harmless there if the rest fails.
+	// Sweeping a large balance needs a recent login, like a large transfer
+	err = h.callPaymentService(c.Request.Context(), http.MethodPost, "/internal/accounts/"+user.ID+"/close", gin.H{
+		"sweep_to_email": req.SweepToEmail,
+		"auth_time":      c.GetTime("auth_time").Unix(),
+		"amr":            c.GetStringSlice("amr"),
+	}, nil)
+	var rejected *paymentServiceError
+	if errors.As(err, &rejected) && rejected.status < http.StatusInternalServerError {
+		c.JSON(rejected.status, gin.H{"error": rejected.message})
+		return
+	}
+	if err != nil {
+		log.Printf("failed to close payment account of user %s: %v", user.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to close payment account"})
+		return
+	}
+
+	if err := h.repo.CloseUser(c.Request.Context(), user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to close account"})
+		return
+	}
+	auditRedact(c)
+
+	if err := h.repo.RecordSecurityEvent(c.Request.Context(), user.ID, "account_closed", map[string]interface{}{}); err != nil {
+		log.Printf("failed to record account closure for user %s: %v", user.ID, err)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ExportData returns a zip archive with everything both services store
+// about the current user.
+func (h *AuthHandler) ExportData(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	user, err := h.repo.FindByID(ctx, c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	sessions, err := h.repo.ListSessions(ctx, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list sessions"})
+		return
+	}
+
+	events, err := h.repo.ListSecurityEvents(ctx, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list security events"})
+		return
+	}
+
+	activity, err := h.repo.ListAuditEvents(ctx, models.AuditFilter{UserID: user.ID, Limit: 10000})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list activity"})
+		return
+	}
+
+	pats, err := h.repo.ListPersonalAccessTokens(ctx, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list tokens"})
+		return
+	}
+
+	var payments json.RawMessage
+	if err := h.callPaymentService(ctx, http.MethodGet, "/internal/accounts/"+user.ID+"/export", nil, &payments); err != nil {
+		log.Printf("failed to export payment data of user %s: %v", user.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to export payment data"})
+		return
+	}
+
+	archive, err := buildExportArchive(map[string]interface{}{
+		"auth/profile.json":         user,
+		"auth/sessions.json":        sessions,
+		"auth/security_events.json": events,
+		"auth/activity.json":        activity,
+		"auth/access_tokens.json":   pats,
+		"payments/account.json":     payments,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build export"})
+		return
+	}
+
+	filename := fmt.Sprintf("dogpay-export-%s.zip", time.Now().Format("2006-01-02"))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Data(http.StatusOK, "application/zip", archive)
+}
+
+func buildExportArchive(files map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
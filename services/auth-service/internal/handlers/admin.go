@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// SearchUsers lets staff with users:read find accounts by email, name or ID.
+func (h *AuthHandler) SearchUsers(c *gin.Context) {
+	var filter models.UserFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	auditDetail(c, "query", filter.Query)
+
+	users, err := h.repo.SearchUsers(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search users"})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+// adminTarget loads the user an admin route is about and names them as the
+// subject of the audit event.
+func (h *AuthHandler) adminTarget(c *gin.Context) (*models.AdminUser, bool) {
+	user, err := h.repo.FindAdminUser(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return nil, false
+	}
+	auditSubject(c, user.ID)
+	return user, true
+}
+
+func (h *AuthHandler) GetUser(c *gin.Context) {
+	user, ok := h.adminTarget(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func (h *AuthHandler) ListUserSessions(c *gin.Context) {
+	user, ok := h.adminTarget(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.repo.ListSessions(c.Request.Context(), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+// ListUserAuditEvents returns the events about a user, filtered and paged
+// like SearchAuditEvents.
+func (h *AuthHandler) ListUserAuditEvents(c *gin.Context) {
+	var filter models.AuditFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.adminTarget(c)
+	if !ok {
+		return
+	}
+	filter.UserID = user.ID
+	filter.Email = ""
+
+	events, err := h.repo.ListAuditEvents(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list audit events"})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// DisableUser signs a user out everywhere and keeps them from signing in
+// until EnableUser. Admins can't disable themselves.
+func (h *AuthHandler) DisableUser(c *gin.Context) {
+	var req models.DisableUserRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	user, ok := h.adminTarget(c)
+	if !ok {
+		return
+	}
+	if req.Reason != "" {
+		auditDetail(c, "reason", req.Reason)
+	}
+	if user.ID == c.GetString("user_id") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot disable your own account"})
+		return
+	}
+
+	disabled, err := h.repo.DisableUser(c.Request.Context(), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable user"})
+		return
+	}
+	if !disabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "user is closed or already disabled"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) EnableUser(c *gin.Context) {
+	user, ok := h.adminTarget(c)
+	if !ok {
+		return
+	}
+
+	enabled, err := h.repo.EnableUser(c.Request.Context(), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable user"})
+		return
+	}
+	if !enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "user is not disabled"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ForceLogout ends every session of a user, like their own logout-all.
+func (h *AuthHandler) ForceLogout(c *gin.Context) {
+	user, ok := h.adminTarget(c)
+	if !ok {
+		return
+	}
+
+	if err := h.repo.DeleteUserRefreshTokens(c.Request.Context(), user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke refresh tokens"})
+		return
+	}
+	if err := h.repo.RevokeUserAccessTokens(c.Request.Context(), user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke access tokens"})
+		return
+	}
+	if err := h.repo.DeleteUserPersonalAccessTokens(c.Request.Context(), user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke personal access tokens"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// UnlockUser lifts a lockout from failed logins before it expires.
+func (h *AuthHandler) UnlockUser(c *gin.Context) {
+	user, ok := h.adminTarget(c)
+	if !ok {
+		return
+	}
+	auditDetail(c, "failed_login_count", user.FailedLoginCount)
+
+	if err := h.repo.UnlockUser(c.Request.Context(), user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlock user"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// auditEvents names the event recorded for each audited route. Reads that
+// do not change or reveal credentials are left out, except staff reads of
+// other users' data.
+var auditEvents = map[string]string{
+	"POST /auth/register":               "register",
+	"POST /auth/login":                  "login",
+	"POST /auth/magic-link":             "magic_link_requested",
+	"POST /auth/magic-link/verify":      "magic_link_login",
+	"POST /auth/refresh":                "token_refresh",
+	"POST /auth/logout":                 "logout",
+	"POST /auth/logout-all":             "logout_all",
+	"POST /auth/password/forgot":        "password_reset_requested",
+	"POST /auth/password/reset":         "password_reset",
+	"POST /auth/password/change":        "password_change",
+	"POST /auth/verify-email":           "email_verification",
+	"POST /auth/verify-email/resend":    "email_verification_resent",
+	"POST /auth/email/change":           "email_change_requested",
+	"POST /auth/email/change/confirm":   "email_change_confirmed",
+	"POST /auth/email/change/cancel":    "email_change_cancelled",
+	"POST /auth/mfa/verify":             "mfa_verify",
+	"POST /auth/mfa/totp/enroll":        "totp_enroll",
+	"POST /auth/mfa/totp/confirm":       "totp_confirm",
+	"POST /auth/mfa/totp/disable":       "totp_disable",
+	"POST /auth/mfa/recovery-codes":     "recovery_codes_regenerated",
+	"POST /auth/step-up":                "step_up",
+	"DELETE /auth/sessions/:id":         "session_revoke",
+	"POST /auth/tokens":                 "personal_access_token_create",
+	"DELETE /auth/tokens/:id":           "personal_access_token_revoke",
+	"PATCH /auth/me":                    "profile_update",
+	"POST /auth/me/close":               "account_close",
+	"GET /auth/me/export":               "data_export",
+	"POST /oauth/consent/:id":           "oauth_consent",
+	"POST /oauth/token":                 "oauth_token",
+	"POST /oauth/clients":               "oauth_client_create",
+	"GET /admin/users":                  "admin_user_search",
+	"GET /admin/users/:id":              "admin_user_view",
+	"GET /admin/users/:id/sessions":     "admin_sessions_view",
+	"GET /admin/users/:id/audit-events": "admin_audit_view",
+	"POST /admin/users/:id/disable":     "admin_user_disable",
+	"POST /admin/users/:id/enable":      "admin_user_enable",
+	"POST /admin/users/:id/logout":      "admin_force_logout",
+	"POST /admin/users/:id/unlock":      "admin_user_unlock",
+}
+
+// auditWriter keeps the error message of failed responses for the event.
+type auditWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.Status() >= 400 && w.body.Len() < 1024 {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Audit records an event in auth.audit_events for every request to a route
+// in auditEvents, once the handler has run. The outcome follows the status
+// code; handlers name the account concerned with auditSubject when nobody
+// is signed in and can add details with auditDetail.
+func (h *AuthHandler) Audit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		eventType, ok := auditEvents[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			c.Next()
+			return
+		}
+
+		writer := &auditWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		event := &models.AuditEvent{
+			EventType: eventType,
+			Outcome:   models.AuditSuccess,
+			IPAddress: c.ClientIP(),
+			UserAgent: clientInfo(c).UserAgent,
+			Details:   map[string]interface{}{},
+		}
+		if actor := c.GetString("user_id"); actor != "" {
+			event.ActorID = &actor
+			event.UserID = &actor
+		}
+		if subject := c.GetString("audit_user_id"); subject != "" {
+			event.UserID = &subject
+		}
+		if details, ok := c.Get("audit_details"); ok {
+			event.Details = details.(map[string]interface{})
+		}
+		if outcome := c.GetString("audit_outcome"); outcome != "" {
+			event.Outcome = outcome
+		}
+		if c.GetBool("audit_redact") {
+			event.IPAddress, event.UserAgent = "", ""
+			event.Details = map[string]interface{}{}
+		}
+		if status := writer.Status(); status >= 400 {
+			event.Outcome = models.AuditFailure
+			event.Details["status"] = status
+			var payload struct {
+				Error string `json:"error"`
+			}
+			if json.Unmarshal(writer.body.Bytes(), &payload) == nil && payload.Error != "" {
+				event.Details["reason"] = payload.Error
+			}
+		}
+
+		// The request context may already be cancelled by a client that hung up
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := h.repo.RecordAuditEvent(ctx, event); err != nil {
+			log.Printf("failed to record audit event %s: %v", eventType, err)
+		}
+	}
+}
+
+// auditSubject names the account an unauthenticated request was about.
+func auditSubject(c *gin.Context, userID string) {
+	c.Set("audit_user_id", userID)
+}
+
+func auditDetail(c *gin.Context, key string, value interface{}) {
+	details, ok := c.Get("audit_details")
+	if !ok {
+		details = map[string]interface{}{}
+		c.Set("audit_details", details)
+	}
+	details.(map[string]interface{})[key] = value
+}
+
+func auditOutcome(c *gin.Context, outcome string) {
+	c.Set("audit_outcome", outcome)
+}
+
+// auditRedact records the event without IP address, user agent or details,
+// for requests whose user's personal data was just erased.
+func auditRedact(c *gin.Context) {
+	c.Set("audit_redact", true)
+}
+
+// Activity returns the audit events about the current user, newest first.
+func (h *AuthHandler) Activity(c *gin.Context) {
+	var filter models.AuditFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Users only filter their own events by type and page
+	events, err := h.repo.ListAuditEvents(c.Request.Context(), models.AuditFilter{
+		UserID:    c.GetString("user_id"),
+		EventType: filter.EventType,
+		Before:    filter.Before,
+		Limit:     filter.Limit,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list activity"})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// SearchAuditEvents lets staff with audit:read search every event.
+func (h *AuthHandler) SearchAuditEvents(c *gin.Context) {
+	var filter models.AuditFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	events, err := h.repo.ListAuditEvents(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search audit events"})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
@@ -1,28 +1,51 @@
 package handlers
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
+	"github.com/dogpay/auth-service/internal/keys"
+	"github.com/dogpay/auth-service/internal/mailer"
 	"github.com/dogpay/auth-service/internal/middleware"
 	"github.com/dogpay/auth-service/internal/models"
+	"github.com/dogpay/auth-service/internal/password"
 	"github.com/dogpay/auth-service/internal/repository"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthHandler struct {
 	repo      *repository.UserRepository
-	jwtSecret string
+	keys      *keys.Keyring
+	passwords password.Hasher
+	policy    *password.Policy
+	mailer    mailer.Mailer
+	appURL    string
+	issuer    string
+
+	serviceTokens serviceTokenCache
+	outboxWake    chan struct{}
 }
 
-func NewAuthHandler(repo *repository.UserRepository, jwtSecret string) *AuthHandler {
-	return &AuthHandler{repo: repo, jwtSecret: jwtSecret}
+func NewAuthHandler(repo *repository.UserRepository, keyring *keys.Keyring, passwords password.Hasher, policy *password.Policy, m mailer.Mailer, appURL, issuer string) *AuthHandler {
+	return &AuthHandler{
+		repo:      repo,
+		keys:      keyring,
+		passwords: passwords,
+		policy:    policy,
+		mailer:    m,
+		appURL:    strings.TrimRight(appURL, "/"),
+		issuer:    strings.TrimRight(issuer, "/"),
+
+		outboxWake: make(chan struct{}, 1),
+	}
 }
 
 func (h *AuthHandler) Register(c *gin.Context) {
@@ -31,38 +54,32 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	auditDetail(c, "email", req.Email)
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+	if !h.enforcePolicy(c, req.Password, req.Email, req.Name) {
 		return
 	}
 
-	user, err := h.repo.Create(c.Request.Context(), req.Email, string(hash), req.Name)
+	hash, err := h.passwords.Hash(req.Password)
 	if err != nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "email already registered"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
 		return
 	}
 
-	// Notify payment service to create account
-	go notifyPaymentService(user.ID)
-
-	accessToken, refreshToken, err := h.generateTokens(user)
+	user, err := h.repo.Create(c.Request.Context(), req.Email, hash, req.Name)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate tokens"})
+		c.JSON(http.StatusConflict, gin.H{"error": "email already registered"})
 		return
 	}
 
-	if err := h.storeRefreshToken(c, user.ID, refreshToken); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store refresh token"})
-		return
+	// The payment account is opened by the user.registered outbox event
+	h.wakeOutbox()
+
+	if err := h.sendVerificationEmail(c, user); err != nil {
+		log.Printf("failed to start email verification for user %s: %v", user.ID, err)
 	}
 
-	c.JSON(http.StatusCreated, models.AuthResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		User:         user,
-	})
+	h.respondWithTokens(c, http.StatusCreated, user, "", models.NewAuthentication(models.AMRPassword))
 }
 
 func (h *AuthHandler) Login(c *gin.Context) {
@@ -71,34 +88,49 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	auditDetail(c, "email", req.Email)
+
+	if !h.allowLoginFromIP(c) {
+		return
+	}
 
 	user, err := h.repo.FindByEmail(c.Request.Context(), req.Email)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
+	auditSubject(c, user.ID)
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+	// Locked accounts get the same response as a wrong password, so a
+	// lockout does not confirm the account exists
+	if user.IsLocked() {
+		auditDetail(c, "locked", true)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
 
-	accessToken, refreshToken, err := h.generateTokens(user)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate tokens"})
+	if !h.checkPassword(c, user, req.Password) {
+		auditDetail(c, "wrong_password", true)
+		h.recordLoginFailure(c, user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
 
-	if err := h.storeRefreshToken(c, user.ID, refreshToken); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store refresh token"})
+	// Only told after the right password, so it does not confirm the account
+	if user.DisabledAt != nil {
+		auditDetail(c, "disabled", true)
+		c.JSON(http.StatusForbidden, gin.H{"error": "account disabled"})
 		return
 	}
 
-	c.JSON(http.StatusOK, models.AuthResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		User:         user,
-	})
+	// The failure streak only ends once every factor has been verified
+	if user.TOTPEnabledAt != nil {
+		h.startMFAChallenge(c, user, models.AMRPassword)
+		return
+	}
+
+	h.clearLoginFailures(c, user)
+	h.respondWithTokens(c, http.StatusOK, user, "", models.NewAuthentication(models.AMRPassword))
 }
 
 func (h *AuthHandler) Me(c *gin.Context) {
@@ -111,6 +143,29 @@ func (h *AuthHandler) Me(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
+// UpdateProfile changes the name of the current user. Payment service reads
+// names straight from auth.users, so counterparties see it right away.
+func (h *AuthHandler) UpdateProfile(c *gin.Context) {
+	var req models.UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if len([]rune(name)) < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be at least 2 characters"})
+		return
+	}
+
+	user, err := h.repo.UpdateName(c.Request.Context(), c.GetString("user_id"), name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
 func (h *AuthHandler) Refresh(c *gin.Context) {
 	var req models.RefreshRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -119,79 +174,275 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 	}
 
 	tokenHash := hashToken(req.RefreshToken)
-	userID, err := h.repo.FindRefreshToken(c.Request.Context(), tokenHash)
+	stored, err := h.repo.FindRefreshToken(c.Request.Context(), tokenHash)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
 		return
 	}
+	auditSubject(c, stored.UserID)
+	auditDetail(c, "session_id", stored.FamilyID)
 
-	user, err := h.repo.FindByID(c.Request.Context(), userID)
+	if stored.RotatedAt != nil {
+		h.revokeReusedFamily(c, stored)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+		return
+	}
+
+	// Third-party sessions are refreshed through the OAuth token endpoint,
+	// which keeps them to their scopes
+	if stored.ClientID != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+		return
+	}
+
+	if !dpopBindingMatches(c, stored) {
+		auditDetail(c, "dpop_mismatch", true)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_dpop_proof"})
+		return
+	}
+
+	user, err := h.repo.FindByID(c.Request.Context(), stored.UserID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 		return
 	}
+	if user.DisabledAt != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+		return
+	}
+
+	rotated, err := h.repo.RotateRefreshToken(c.Request.Context(), tokenHash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rotate refresh token"})
+		return
+	}
+	if !rotated {
+		// Another request rotated this token between our read and write
+		h.revokeReusedFamily(c, stored)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+		return
+	}
+
+	// Refreshing keeps the original authentication time and methods
+	h.respondWithTokens(c, http.StatusOK, user, stored.FamilyID, stored.Authentication())
+}
+
+// revokeReusedFamily ends the whole session when a rotated refresh token is
+// presented again: either the legitimate client or an attacker holds a copy.
+func (h *AuthHandler) revokeReusedFamily(c *gin.Context, stored *models.RefreshToken) {
+	auditDetail(c, "refresh_token_reuse", true)
+	ctx := c.Request.Context()
+	if err := h.repo.DeleteRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+		log.Printf("failed to revoke refresh token family %s: %v", stored.FamilyID, err)
+	}
+	err := h.repo.RecordSecurityEvent(ctx, stored.UserID, "refresh_token_reuse", map[string]interface{}{
+		"family_id":  stored.FamilyID,
+		"token_id":   stored.ID,
+		"ip":         c.ClientIP(),
+		"user_agent": c.Request.UserAgent(),
+	})
+	if err != nil {
+		log.Printf("failed to record refresh token reuse for user %s: %v", stored.UserID, err)
+	}
+	log.Printf("refresh token reuse detected for user %s, family %s revoked", stored.UserID, stored.FamilyID)
+}
+
+// Logout only needs the refresh token, so sessions with an expired access
+// token can still be ended. An access token sent along is revoked as well.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req models.LogoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Unknown or expired tokens are already unusable, so logout stays idempotent
+	stored, err := h.repo.FindRefreshToken(c.Request.Context(), hashToken(req.RefreshToken))
+	if err != nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	auditSubject(c, stored.UserID)
+	auditDetail(c, "session_id", stored.FamilyID)
 
-	_ = h.repo.DeleteRefreshToken(c.Request.Context(), tokenHash)
+	if err := h.repo.DeleteRefreshTokenFamily(c.Request.Context(), stored.FamilyID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke refresh token"})
+		return
+	}
+
+	if _, raw, ok := strings.Cut(c.GetHeader("Authorization"), " "); ok {
+		claims, err := middleware.ParseToken(h.keys, raw)
+		if err == nil && claims.IsAccessToken() && claims.ID != "" && claims.ExpiresAt != nil && claims.UserID == stored.UserID {
+			if err := h.repo.RevokeAccessToken(c.Request.Context(), claims.ID, &claims.UserID, claims.ExpiresAt.Time); err != nil {
+				log.Printf("failed to revoke access token of user %s: %v", stored.UserID, err)
+			}
+		}
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	userID := c.GetString("user_id")
 
-	accessToken, refreshToken, err := h.generateTokens(user)
+	if err := h.repo.DeleteUserRefreshTokens(c.Request.Context(), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke refresh tokens"})
+		return
+	}
+	if err := h.repo.RevokeUserAccessTokens(c.Request.Context(), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke access tokens"})
+		return
+	}
+	if err := h.repo.DeleteUserPersonalAccessTokens(c.Request.Context(), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke personal access tokens"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.keys.JWKS())
+}
+
+// respondWithTokens issues a token pair for user and writes the
+// AuthResponse. The refresh token joins familyID, or starts a new session
+// when familyID is empty; authn describes how the session was authenticated.
+func (h *AuthHandler) respondWithTokens(c *gin.Context, status int, user *models.User, familyID string, authn models.Authentication) {
+	refreshToken, err := h.generateRefreshToken(user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate tokens"})
 		return
 	}
 
-	if err := h.storeRefreshToken(c, user.ID, refreshToken); err != nil {
+	auditSubject(c, user.ID)
+
+	// The access token names its session, so the family ID must be known first
+	familyID, err = h.storeRefreshToken(c, user.ID, familyID, refreshToken, authn)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store refresh token"})
 		return
 	}
+	auditDetail(c, "session_id", familyID)
+	auditDetail(c, "amr", authn.Methods)
+
+	accessToken, err := h.generateAccessToken(c.Request.Context(), user, familyID, authn, c.GetString("dpop_jkt"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate tokens"})
+		return
+	}
 
-	c.JSON(http.StatusOK, models.AuthResponse{
+	c.JSON(status, models.AuthResponse{
 		AccessToken:  accessToken,
+		TokenType:    tokenType(c),
 		RefreshToken: refreshToken,
 		User:         user,
 	})
 }
 
-func (h *AuthHandler) generateTokens(user *models.User) (string, string, error) {
-	accessExpiry := 15 * time.Minute
+// dpopBindingMatches reports whether a refresh request carries a proof from
+// the key its session is bound to, if any.
+func dpopBindingMatches(c *gin.Context, stored *models.RefreshToken) bool {
+	return stored.DPoPJKT == nil || *stored.DPoPJKT == c.GetString("dpop_jkt")
+}
+
+// tokenType is the token_type of tokens issued for the request.
+func tokenType(c *gin.Context) string {
+	if c.GetString("dpop_jkt") != "" {
+		return "DPoP"
+	}
+	return "Bearer"
+}
+
+// confirmation binds an access token to the request's DPoP key, if any.
+func confirmation(jkt string) *middleware.Confirmation {
+	if jkt == "" {
+		return nil
+	}
+	return &middleware.Confirmation{JKT: jkt}
+}
+
+func (h *AuthHandler) generateRefreshToken(user *models.User) (string, error) {
 	refreshExpiry := 7 * 24 * time.Hour
 
-	accessClaims := &middleware.Claims{
-		UserID: user.ID,
-		Email:  user.Email,
+	// A random ID keeps tokens issued within the same second distinct
+	refreshID, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	refreshClaims := &middleware.Claims{
+		UserID:   user.ID,
+		Email:    user.Email,
+		TokenUse: middleware.TokenUseRefresh,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessExpiry)),
+			ID:        refreshID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(refreshExpiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Subject:   user.ID,
 		},
 	}
 
-	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims).SignedString([]byte(h.jwtSecret))
+	return h.keys.Sign(refreshClaims)
+}
+
+// generateAccessToken issues an access token, bound to the DPoP key with
+// thumbprint jkt unless that is empty.
+func (h *AuthHandler) generateAccessToken(ctx context.Context, user *models.User, sessionID string, authn models.Authentication, jkt string) (string, error) {
+	accessExpiry := 15 * time.Minute
+
+	// Role changes show up in the next access token, within 15 minutes
+	roles, permissions, err := h.repo.UserAccess(ctx, user.ID)
+	if err != nil {
+		return "", err
+	}
+
+	// The jti lets the token be revoked on its own
+	tokenID, err := randomToken(16)
 	if err != nil {
-		return "", "", err
+		return "", err
 	}
 
-	refreshClaims := &middleware.Claims{
-		UserID: user.ID,
-		Email:  user.Email,
+	accessClaims := &middleware.Claims{
+		UserID:        user.ID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		AuthTime:      jwt.NewNumericDate(authn.Time),
+		AMR:           authn.Methods,
+		ACR:           authn.ACR(),
+		SessionID:     sessionID,
+		Roles:         roles,
+		Permissions:   permissions,
+		Confirmation:  confirmation(jkt),
+		TokenUse:      middleware.TokenUseAccess,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(refreshExpiry)),
+			ID:        tokenID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessExpiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Subject:   user.ID,
 		},
 	}
 
-	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString([]byte(h.jwtSecret))
-	if err != nil {
-		return "", "", err
-	}
+	return h.keys.Sign(accessClaims)
+}
 
-	return accessToken, refreshToken, nil
+func (h *AuthHandler) storeRefreshToken(c *gin.Context, userID, familyID, refreshToken string, authn models.Authentication) (string, error) {
+	return h.repo.StoreRefreshToken(c.Request.Context(), &models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		AuthTime:  authn.Time,
+		AMR:       authn.Methods,
+		DPoPJKT:   dpopJKT(c),
+		ExpiresAt: time.Now().Add(7 * 24 * time.Hour),
+	}, hashToken(refreshToken), clientInfo(c))
 }
 
-func (h *AuthHandler) storeRefreshToken(c *gin.Context, userID, refreshToken string) error {
-	tokenHash := hashToken(refreshToken)
-	expiresAt := time.Now().Add(7 * 24 * time.Hour)
-	return h.repo.StoreRefreshToken(c.Request.Context(), userID, tokenHash, expiresAt)
+func dpopJKT(c *gin.Context) *string {
+	if jkt := c.GetString("dpop_jkt"); jkt != "" {
+		return &jkt
+	}
+	return nil
 }
 
 func hashToken(token string) string {
@@ -200,29 +451,10 @@ func hashToken(token string) string {
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
-func notifyPaymentService(userID string) {
-	paymentURL := os.Getenv("PAYMENT_SERVICE_URL")
-	if paymentURL == "" {
-		paymentURL = "http://payment-service:8002"
-	}
-
-	body := fmt.Sprintf(`{"user_id":"%s"}`, userID)
-
-	// Simple HTTP call to payment service to create account
-	// Retry a few times to handle startup race conditions
-	for i := 0; i < 5; i++ {
-		time.Sleep(time.Duration(i+1) * time.Second)
-		resp, err := http.Post(
-			paymentURL+"/internal/accounts",
-			"application/json",
-			strings.NewReader(body),
-		)
-		if err != nil {
-			continue
-		}
-		resp.Body.Close()
-		if resp.StatusCode == http.StatusCreated || resp.StatusCode == http.StatusOK {
-			return
-		}
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dogpay/auth-service/internal/mailer"
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/dogpay/auth-service/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	emailChangeExpiry = 24 * time.Hour
+	// The old address can undo the change for longer than the new one
+	// has to confirm it
+	emailChangeCancelExpiry = 7 * 24 * time.Hour
+)
+
+// RequestEmailChange starts moving the account to a new address. Nothing
+// changes until the link sent to the new address is confirmed; the old
+// address is told about the request and can cancel it.
+func (h *AuthHandler) RequestEmailChange(c *gin.Context) {
+	var req models.EmailChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.repo.FindByID(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if h.refuseLocked(c, user) {
+		return
+	}
+
+	if !h.checkPassword(c, user, req.Password) {
+		auditDetail(c, "wrong_password", true)
+		h.recordLoginFailure(c, user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		return
+	}
+	h.clearLoginFailures(c, user)
+
+	newEmail := strings.TrimSpace(req.NewEmail)
+	if strings.EqualFold(newEmail, user.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "new email is the current email"})
+		return
+	}
+	if _, err := h.repo.FindByEmail(c.Request.Context(), newEmail); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "email already registered"})
+		return
+	}
+
+	confirmToken, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+	cancelToken, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	now := time.Now()
+	err = h.repo.CreateEmailChange(
+		c.Request.Context(), user.ID, user.Email, newEmail,
+		hashToken(confirmToken), hashToken(cancelToken),
+		now.Add(emailChangeExpiry), now.Add(emailChangeCancelExpiry),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store email change"})
+		return
+	}
+
+	go h.sendMail(mailer.Message{
+		To:      newEmail,
+		Subject: "Confirme seu novo email no DogPay",
+		Body: fmt.Sprintf(
+			"Olá, %s!\n\nConfirme que este será o novo email da sua conta DogPay:\n\n%s\n\n"+
+				"O link expira em 24 horas. Até lá, o login e as transferências continuam usando %s.\n",
+			user.Name, h.appLink("/confirm-email-change", confirmToken), user.Email,
+		),
+	})
+	go h.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Pedido de troca de email no DogPay",
+		Body: fmt.Sprintf(
+			"Olá, %s!\n\nRecebemos um pedido para trocar o email da sua conta DogPay para %s.\n\n"+
+				"Se não foi você, cancele a troca pelo link abaixo. Ele vale por 7 dias e desfaz a troca "+
+				"mesmo que ela já tenha sido confirmada, encerrando todas as sessões:\n\n%s\n",
+			user.Name, newEmail, h.appLink("/cancel-email-change", cancelToken),
+		),
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "confirmation link sent to the new email"})
+}
+
+func (h *AuthHandler) ConfirmEmailChange(c *gin.Context) {
+	var req models.EmailChangeTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.repo.ConfirmEmailChange(c.Request.Context(), hashToken(req.Token))
+	if errors.Is(err, repository.ErrEmailTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "email already registered"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired confirmation token"})
+		return
+	}
+
+	auditSubject(c, user.ID)
+	auditDetail(c, "new_email", user.Email)
+
+	err = h.repo.RecordSecurityEvent(c.Request.Context(), user.ID, "email_changed", map[string]interface{}{
+		"new_email": user.Email,
+		"ip":        c.ClientIP(),
+	})
+	if err != nil {
+		log.Printf("failed to record email change for user %s: %v", user.ID, err)
+	}
+
+	// Access tokens keep the old email claim until the next refresh
+	c.JSON(http.StatusOK, user)
+}
+
+func (h *AuthHandler) CancelEmailChange(c *gin.Context) {
+	var req models.EmailChangeTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	change, err := h.repo.CancelEmailChange(c.Request.Context(), hashToken(req.Token))
+	if errors.Is(err, repository.ErrEmailTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "old email is now registered to another account"})
+		return
+	}
+	if errors.Is(err, repository.ErrEmailChanged) {
+		c.JSON(http.StatusConflict, gin.H{"error": "email has changed again since this change"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired cancel token"})
+		return
+	}
+
+	auditSubject(c, change.UserID)
+	auditDetail(c, "reverted", change.ConfirmedAt != nil)
+
+	if change.ConfirmedAt != nil {
+		err = h.repo.RecordSecurityEvent(c.Request.Context(), change.UserID, "email_change_reverted", map[string]interface{}{
+			"old_email": change.OldEmail,
+			"new_email": change.NewEmail,
+			"ip":        c.ClientIP(),
+		})
+		if err != nil {
+			log.Printf("failed to record email change revert for user %s: %v", change.UserID, err)
+		}
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/dogpay/auth-service/internal/middleware"
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// introspectScope is the service scope a client needs to introspect tokens.
+const introspectScope = "tokens:introspect"
+
+// Introspect is the RFC 7662 introspection endpoint for access tokens.
+// Service clients with the tokens:introspect scope authenticate like at the
+// token endpoint. Anything that is not a valid, unrevoked access token is
+// reported as inactive, without saying why.
+func (h *AuthHandler) Introspect(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	client, err := h.authenticateClient(c)
+	if err != nil || client.Public() {
+		c.Header("WWW-Authenticate", `Basic realm="dogpay"`)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+		return
+	}
+	if !slices.Contains(client.Scopes, introspectScope) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "unauthorized_client", "error_description": "client may not introspect tokens"})
+		return
+	}
+
+	raw := c.PostForm("token")
+	if raw == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "token is required"})
+		return
+	}
+
+	inactive := models.IntrospectionResponse{Active: false}
+	claims, err := middleware.ParseToken(h.keys, raw)
+	if err != nil || !claims.IsAccessToken() || claims.ExpiresAt == nil {
+		c.JSON(http.StatusOK, inactive)
+		return
+	}
+
+	active, err := middleware.TokenActive(c.Request.Context(), h.repo, claims)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	if !active {
+		c.JSON(http.StatusOK, inactive)
+		return
+	}
+
+	resp := models.IntrospectionResponse{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		Username:  claims.Email,
+		TokenType: "Bearer",
+		ExpiresAt: claims.ExpiresAt.Unix(),
+		IssuedAt:  claims.IssuedAt.Unix(),
+		Subject:   claims.Subject,
+		Audience:  claims.Audience,
+		Issuer:    claims.Issuer,
+		TokenID:   claims.ID,
+		SessionID: claims.SessionID,
+	}
+	if claims.Confirmation != nil && claims.Confirmation.JKT != "" {
+		resp.TokenType = "DPoP"
+		resp.Confirmation = &models.TokenConfirmation{JKT: claims.Confirmation.JKT}
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dogpay/auth-service/internal/mailer"
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	magicLinkExpiry = 15 * time.Minute
+	// At most magicLinkLimit emails per account and window, so the endpoint
+	// can't be used to flood someone's inbox
+	magicLinkLimit  = 3
+	magicLinkWindow = 15 * time.Minute
+)
+
+// RequestMagicLink emails a single-use login link. The response carries a
+// binding secret that must accompany the link, tying it to the client that
+// asked for it. It looks the same whether or not the account exists.
+func (h *AuthHandler) RequestMagicLink(c *gin.Context) {
+	var req models.MagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	auditDetail(c, "email", req.Email)
+
+	if !h.allowLoginFromIP(c) {
+		return
+	}
+
+	binding, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate login link"})
+		return
+	}
+	accepted := models.MagicLinkResponse{
+		Message:       "if the email is registered, a login link has been sent",
+		BindingSecret: binding,
+		ExpiresIn:     int(magicLinkExpiry.Seconds()),
+	}
+
+	// Locked, disabled and closed accounts are answered like unknown ones
+	user, err := h.repo.FindByEmail(c.Request.Context(), req.Email)
+	if err != nil || user.IsLocked() || user.ClosedAt != nil || user.DisabledAt != nil {
+		c.JSON(http.StatusAccepted, accepted)
+		return
+	}
+	auditSubject(c, user.ID)
+
+	count, err := h.repo.HitRateLimit(c.Request.Context(), "magic-link:user:"+user.ID, magicLinkWindow)
+	if err != nil {
+		log.Printf("magic link rate limit unavailable: %v", err)
+	} else if count > magicLinkLimit {
+		auditDetail(c, "rate_limited", true)
+		c.JSON(http.StatusAccepted, accepted)
+		return
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate login link"})
+		return
+	}
+
+	expiresAt := time.Now().Add(magicLinkExpiry)
+	if err := h.repo.CreateMagicLink(c.Request.Context(), user.ID, hashToken(token), hashToken(binding), expiresAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store login link"})
+		return
+	}
+
+	client := clientInfo(c)
+	go h.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Seu link de acesso ao DogPay",
+		Body: fmt.Sprintf(
+			"Olá, %s!\n\nUse o link abaixo para entrar na sua conta DogPay. Ele vale por %d minutos, "+
+				"só pode ser usado uma vez e só funciona no aparelho em que você pediu o acesso:\n\n%s\n\n"+
+				"Pedido feito de %s (IP %s).\n"+
+				"Se não foi você, ignore este email. Ninguém entra na sua conta sem este link.\n",
+			user.Name, int(magicLinkExpiry.Minutes()), h.appLink("/magic-link", token), client.UserAgent, client.IPAddress,
+		),
+	})
+
+	c.JSON(http.StatusAccepted, accepted)
+}
+
+// VerifyMagicLink signs in with a link and its binding secret. It follows
+// the rules of Login: the per-IP limit, lockout after repeated failures and
+// a second factor when TOTP is enabled.
+func (h *AuthHandler) VerifyMagicLink(c *gin.Context) {
+	var req models.MagicLinkVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !h.allowLoginFromIP(c) {
+		return
+	}
+
+	link, err := h.repo.FindMagicLink(c.Request.Context(), hashToken(req.Token))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired login link"})
+		return
+	}
+	auditSubject(c, link.UserID)
+
+	user, err := h.repo.FindByID(c.Request.Context(), link.UserID)
+	if err != nil || user.IsLocked() || user.ClosedAt != nil || user.DisabledAt != nil {
+		if err == nil && user.IsLocked() {
+			auditDetail(c, "locked", true)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired login link"})
+		return
+	}
+
+	// A valid link opened on another device is a failed login: someone
+	// other than the requesting client holds the email
+	if subtle.ConstantTimeCompare([]byte(hashToken(req.BindingSecret)), []byte(link.BindingHash)) != 1 {
+		auditDetail(c, "binding_mismatch", true)
+		h.recordLoginFailure(c, user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired login link"})
+		return
+	}
+
+	consumed, err := h.repo.ConsumeMagicLink(c.Request.Context(), link.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to complete login"})
+		return
+	}
+	if !consumed {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired login link"})
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		h.startMFAChallenge(c, user, models.AMREmail)
+		return
+	}
+
+	h.clearLoginFailures(c, user)
+	h.respondWithTokens(c, http.StatusOK, user, "", models.NewAuthentication(models.AMREmail))
+}
@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/dogpay/auth-service/internal/repository"
+	"github.com/dogpay/auth-service/internal/totp"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	totpIssuer         = "DogPay"
+	mfaChallengeExpiry = 5 * time.Minute
+	mfaMaxAttempts     = 5
+	recoveryCodeCount  = 10
+)
+
+// startMFAChallenge answers a successful first factor, an AMR value, with
+// a challenge for the second one.
+func (h *AuthHandler) startMFAChallenge(c *gin.Context, user *models.User, firstFactor string) {
+	auditOutcome(c, models.AuditMFARequired)
+	token, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate mfa token"})
+		return
+	}
+
+	expiresAt := time.Now().Add(mfaChallengeExpiry)
+	if err := h.repo.CreateMFAChallenge(c.Request.Context(), user.ID, hashToken(token), firstFactor, expiresAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store mfa challenge"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		Methods:     []string{"totp", "recovery_code"},
+	})
+}
+
+// VerifyMFA completes a login started by Login with the second factor and
+// only then issues the token pair.
+func (h *AuthHandler) VerifyMFA(c *gin.Context) {
+	var req models.MFAVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	challengeID, userID, firstFactor, err := h.repo.AttemptMFAChallenge(c.Request.Context(), hashToken(req.MFAToken), mfaMaxAttempts)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa token"})
+		return
+	}
+	auditSubject(c, userID)
+	if req.RecoveryCode != "" {
+		auditDetail(c, "recovery_code", true)
+	}
+
+	user, err := h.repo.FindByID(c.Request.Context(), userID)
+	if err != nil || user.TOTPEnabledAt == nil || user.DisabledAt != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa token"})
+		return
+	}
+	// Wrong codes count toward the lockout like wrong passwords
+	if user.IsLocked() {
+		auditDetail(c, "locked", true)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa token"})
+		return
+	}
+
+	// The challenge and the factor are used up together, so a request that
+	// loses a race on the challenge keeps its recovery code
+	ok := false
+	step, codeHash, valid := checkSecondFactor(user, req.Code, req.RecoveryCode)
+	if valid {
+		ok, err = h.repo.CompleteMFAChallenge(c.Request.Context(), challengeID, user.ID, step, codeHash)
+		if errors.Is(err, repository.ErrMFAChallengeConsumed) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa token"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to complete mfa challenge"})
+			return
+		}
+	}
+	if !ok {
+		auditDetail(c, "wrong_code", true)
+		h.recordLoginFailure(c, user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		return
+	}
+
+	// A recovery code proves possession of the account but not of the
+	// authenticator, so it does not count as otp
+	authn := models.NewAuthentication(firstFactor, models.AMROTP, models.AMRMultiFactor)
+	if req.Code == "" {
+		authn = models.NewAuthentication(firstFactor, models.AMRMultiFactor)
+	}
+	h.clearLoginFailures(c, user)
+	h.respondWithTokens(c, http.StatusOK, user, "", authn)
+}
+
+func (h *AuthHandler) EnrollTOTP(c *gin.Context) {
+	user, err := h.repo.FindByID(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "totp already enabled"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate totp secret"})
+		return
+	}
+
+	if err := h.repo.SetPendingTOTPSecret(c.Request.Context(), user.ID, secret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store totp secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.TOTPEnrollResponse{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(totpIssuer, user.Email, secret),
+	})
+}
+
+func (h *AuthHandler) ConfirmTOTP(c *gin.Context) {
+	var req models.TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.repo.FindByID(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "totp already enabled"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "totp enrollment not started"})
+		return
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, req.Code, time.Now())
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate recovery codes"})
+		return
+	}
+
+	if err := h.repo.EnableTOTP(c.Request.Context(), user.ID, step, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable totp"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (h *AuthHandler) DisableTOTP(c *gin.Context) {
+	var req models.TOTPDisableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.repo.FindByID(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	if user.TOTPEnabledAt == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "totp not enabled"})
+		return
+	}
+	if h.refuseLocked(c, user) {
+		return
+	}
+
+	if !h.checkPassword(c, user, req.Password) {
+		auditDetail(c, "wrong_password", true)
+		h.recordLoginFailure(c, user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		return
+	}
+
+	ok, err := h.verifySecondFactor(c, user, req.Code, req.RecoveryCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify code"})
+		return
+	}
+	if !ok {
+		auditDetail(c, "wrong_code", true)
+		h.recordLoginFailure(c, user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		return
+	}
+	h.clearLoginFailures(c, user)
+
+	if err := h.repo.DisableTOTP(c.Request.Context(), user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable totp"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces every recovery code after checking a
+// current TOTP code.
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req models.TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.repo.FindByID(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	if user.TOTPEnabledAt == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "totp not enabled"})
+		return
+	}
+	if h.refuseLocked(c, user) {
+		return
+	}
+
+	ok, err := h.verifySecondFactor(c, user, req.Code, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify code"})
+		return
+	}
+	if !ok {
+		auditDetail(c, "wrong_code", true)
+		h.recordLoginFailure(c, user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		return
+	}
+	h.clearLoginFailures(c, user)
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate recovery codes"})
+		return
+	}
+
+	if err := h.repo.ReplaceRecoveryCodes(c.Request.Context(), user.ID, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code.
+// Both are single-use: a TOTP step can't be replayed and a recovery code is
+// burned on success.
+func (h *AuthHandler) verifySecondFactor(c *gin.Context, user *models.User, code, recoveryCode string) (bool, error) {
+	step, codeHash, ok := checkSecondFactor(user, code, recoveryCode)
+	if !ok {
+		return false, nil
+	}
+	if codeHash != "" {
+		return h.repo.UseRecoveryCode(c.Request.Context(), user.ID, codeHash)
+	}
+	return h.repo.UseTOTPStep(c.Request.Context(), user.ID, step)
+}
+
+// checkSecondFactor returns the step of a valid TOTP code, or else the hash
+// of the recovery code to look up, without using either up.
+func checkSecondFactor(user *models.User, code, recoveryCode string) (step int64, codeHash string, ok bool) {
+	if code != "" {
+		step, ok = totp.Validate(user.TOTPSecret, code, time.Now())
+		return step, "", ok
+	}
+	if recoveryCode != "" {
+		return 0, hashToken(normalizeRecoveryCode(recoveryCode)), true
+	}
+	return 0, "", false
+}
+
+// generateRecoveryCodes returns codes formatted as xxxxx-xxxxx together with
+// the hashes to store.
+func generateRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(b))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashToken(raw)
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
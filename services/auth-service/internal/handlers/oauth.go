@@ -0,0 +1,697 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dogpay/auth-service/internal/middleware"
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/dogpay/auth-service/internal/repository"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	authorizationRequestExpiry = 10 * time.Minute
+	authorizationCodeExpiry    = time.Minute
+	serviceTokenExpiry         = 5 * time.Minute
+)
+
+// oauthError is an RFC 6749 error. Code is one of the registered error
+// codes, which clients switch on; Description is for developers.
+type oauthError struct {
+	Code        string
+	Description string
+}
+
+func (e *oauthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// IDTokenClaims are the OpenID Connect ID token claims.
+type IDTokenClaims struct {
+	Nonce         string           `json:"nonce,omitempty"`
+	AuthTime      *jwt.NumericDate `json:"auth_time,omitempty"`
+	AMR           []string         `json:"amr,omitempty"`
+	ACR           string           `json:"acr,omitempty"`
+	Name          string           `json:"name,omitempty"`
+	Email         string           `json:"email,omitempty"`
+	EmailVerified *bool            `json:"email_verified,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Discovery publishes the OpenID Connect provider metadata.
+func (h *AuthHandler) Discovery(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                         h.issuer,
+		"authorization_endpoint":                         h.issuer + "/oauth/authorize",
+		"token_endpoint":                                 h.issuer + "/oauth/token",
+		"introspection_endpoint":                         h.issuer + "/auth/introspect",
+		"userinfo_endpoint":                              h.issuer + "/oauth/userinfo",
+		"jwks_uri":                                       h.issuer + "/.well-known/jwks.json",
+		"scopes_supported":                               models.OAuthScopes,
+		"response_types_supported":                       []string{"code"},
+		"grant_types_supported":                          []string{"authorization_code", "refresh_token", "client_credentials"},
+		"subject_types_supported":                        []string{"public"},
+		"id_token_signing_alg_values_supported":          []string{"EdDSA"},
+		"token_endpoint_auth_methods_supported":          []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":               []string{"S256"},
+		"claims_supported":                               []string{"sub", "name", "email", "email_verified", "auth_time", "amr", "acr"},
+		"authorization_response_iss_parameter_supported": true,
+		"dpop_signing_alg_values_supported":              []string{"ES256", "EdDSA"},
+	})
+}
+
+// CreateOAuthClient registers a third-party app. The secret of confidential
+// clients is returned once; only its hash is stored.
+func (h *AuthHandler) CreateOAuthClient(c *gin.Context) {
+	var req models.CreateOAuthClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client, secret, err := RegisterOAuthClient(c.Request.Context(), h.repo, req)
+	if errors.Is(err, ErrInvalidClientRequest) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to register client"})
+		return
+	}
+	auditDetail(c, "client_id", client.ID)
+
+	c.JSON(http.StatusCreated, models.CreateOAuthClientResponse{OAuthClient: *client, ClientSecret: secret})
+}
+
+// ErrInvalidClientRequest is returned by RegisterOAuthClient when the
+// requested client is inconsistent, e.g. asks for an unknown scope.
+var ErrInvalidClientRequest = errors.New("invalid client registration")
+
+// RegisterOAuthClient stores a new client with a random ID and, unless it
+// is public, a random secret, which is returned.
+func RegisterOAuthClient(ctx context.Context, repo *repository.UserRepository, req models.CreateOAuthClientRequest) (*models.OAuthClient, string, error) {
+	grantTypes := []string{models.GrantAuthorizationCode, models.GrantRefreshToken}
+	allowed := func(scope string) bool { return slices.Contains(models.OAuthScopes, scope) }
+	if req.Service {
+		if req.Public {
+			return nil, "", fmt.Errorf("%w: service clients must have a secret", ErrInvalidClientRequest)
+		}
+		grantTypes = []string{models.GrantClientCredentials}
+		req.RedirectURIs = []string{}
+		allowed = func(scope string) bool {
+			_, ok := models.ServiceScopes[scope]
+			return ok
+		}
+	} else if len(req.RedirectURIs) == 0 {
+		return nil, "", fmt.Errorf("%w: at least one redirect URI is required", ErrInvalidClientRequest)
+	}
+	for _, scope := range req.Scopes {
+		if !allowed(scope) {
+			return nil, "", fmt.Errorf("%w: scope %s is not available to this kind of client", ErrInvalidClientRequest, scope)
+		}
+	}
+
+	id, err := randomToken(16)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var secret string
+	var secretHash *string
+	if !req.Public {
+		if secret, err = randomToken(32); err != nil {
+			return nil, "", err
+		}
+		hash := hashToken(secret)
+		secretHash = &hash
+	}
+
+	client, err := repo.CreateOAuthClient(ctx, id, secretHash, req.Name, req.RedirectURIs, req.Scopes, grantTypes)
+	if err != nil {
+		return nil, "", err
+	}
+	return client, secret, nil
+}
+
+// Authorize starts the authorization code flow. Once the client and its
+// redirect URI check out, errors go back to the client and the user is sent
+// on to the consent screen of the web app, which signs them in if needed.
+func (h *AuthHandler) Authorize(c *gin.Context) {
+	client, err := h.repo.FindOAuthClient(c.Request.Context(), c.Query("client_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_client", "error_description": "unknown client"})
+		return
+	}
+
+	redirectURI := c.Query("redirect_uri")
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	// Redirect URIs are compared exactly, never by prefix
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "redirect_uri is not registered for this client"})
+		return
+	}
+
+	state := c.Query("state")
+	fail := func(code, description string) {
+		c.Redirect(http.StatusFound, h.redirectURL(redirectURI, url.Values{
+			"error":             {code},
+			"error_description": {description},
+			"state":             {state},
+		}))
+	}
+
+	if !client.Allows(models.GrantAuthorizationCode) {
+		fail("unauthorized_client", "this client cannot use the authorization code flow")
+		return
+	}
+	if c.Query("response_type") != "code" {
+		fail("unsupported_response_type", "only the code response type is supported")
+		return
+	}
+	// OAuth 2.1 requires PKCE for every client, and plain offers no protection
+	// once the authorization request leaks
+	challenge := c.Query("code_challenge")
+	if challenge == "" || c.Query("code_challenge_method") != "S256" {
+		fail("invalid_request", "code_challenge with code_challenge_method S256 is required")
+		return
+	}
+	if len(challenge) < 43 || len(challenge) > 128 {
+		fail("invalid_request", "invalid code_challenge")
+		return
+	}
+
+	scopes := strings.Fields(c.Query("scope"))
+	if len(scopes) == 0 {
+		fail("invalid_scope", "scope is required")
+		return
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			fail("invalid_scope", "scope not allowed for this client: "+scope)
+			return
+		}
+	}
+	slices.Sort(scopes)
+	scopes = slices.Compact(scopes)
+
+	requestID, err := h.repo.CreateAuthorizationRequest(c.Request.Context(), &models.OAuthAuthorizationRequest{
+		ClientID:      client.ID,
+		RedirectURI:   redirectURI,
+		Scope:         strings.Join(scopes, " "),
+		State:         state,
+		Nonce:         c.Query("nonce"),
+		CodeChallenge: challenge,
+		ExpiresAt:     time.Now().Add(authorizationRequestExpiry),
+	})
+	if err != nil {
+		fail("server_error", "failed to store authorization request")
+		return
+	}
+
+	c.Redirect(http.StatusFound, h.appURL+"/oauth/consent?request_id="+url.QueryEscape(requestID))
+}
+
+// GetConsent describes a pending authorization request to the consent
+// screen.
+func (h *AuthHandler) GetConsent(c *gin.Context) {
+	req, err := h.repo.FindAuthorizationRequest(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "authorization request not found or expired"})
+		return
+	}
+
+	client, err := h.repo.FindOAuthClient(c.Request.Context(), req.ClientID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "authorization request not found or expired"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ConsentResponse{
+		RequestID:  req.ID,
+		ClientID:   client.ID,
+		ClientName: client.Name,
+		Scopes:     strings.Fields(req.Scope),
+	})
+}
+
+// DecideConsent records the user's answer to an authorization request and
+// returns where the browser should go next: back to the client with either
+// an authorization code or access_denied.
+func (h *AuthHandler) DecideConsent(c *gin.Context) {
+	var body models.ConsentRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	req, err := h.repo.FindAuthorizationRequest(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "authorization request not found or expired"})
+		return
+	}
+	auditDetail(c, "client_id", req.ClientID)
+	auditDetail(c, "scope", req.Scope)
+	auditDetail(c, "approved", body.Approve)
+
+	deleted, err := h.repo.DeleteAuthorizationRequest(ctx, req.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process consent"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "authorization request not found or expired"})
+		return
+	}
+
+	if !body.Approve {
+		c.JSON(http.StatusOK, models.ConsentDecisionResponse{RedirectTo: h.redirectURL(req.RedirectURI, url.Values{
+			"error":             {"access_denied"},
+			"error_description": {"the user denied the request"},
+			"state":             {req.State},
+		})})
+		return
+	}
+
+	code, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue authorization code"})
+		return
+	}
+
+	// The client's session inherits how the user signed in to the web app
+	authTime := time.Now()
+	if t, ok := c.Get("auth_time"); ok {
+		authTime = t.(time.Time)
+	}
+	err = h.repo.CreateAuthorizationCode(ctx, hashToken(code), &models.OAuthAuthorizationCode{
+		ClientID:      req.ClientID,
+		UserID:        c.GetString("user_id"),
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		AuthTime:      authTime,
+		AMR:           c.GetStringSlice("amr"),
+		ExpiresAt:     time.Now().Add(authorizationCodeExpiry),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue authorization code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ConsentDecisionResponse{RedirectTo: h.redirectURL(req.RedirectURI, url.Values{
+		"code":  {code},
+		"state": {req.State},
+	})})
+}
+
+// Token is the OAuth token endpoint. It takes form-encoded requests and
+// answers in the RFC 6749 error format.
+func (h *AuthHandler) Token(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	client, err := h.authenticateClient(c)
+	if err != nil {
+		c.Header("WWW-Authenticate", `Basic realm="dogpay"`)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client", "error_description": err.Error()})
+		return
+	}
+
+	auditDetail(c, "client_id", client.ID)
+
+	var resp *models.OAuthTokenResponse
+	grantType := c.PostForm("grant_type")
+	auditDetail(c, "grant_type", grantType)
+	switch {
+	case grantType != models.GrantAuthorizationCode && grantType != models.GrantRefreshToken && grantType != models.GrantClientCredentials:
+		err = &oauthError{"unsupported_grant_type", "grant_type must be authorization_code, refresh_token or client_credentials"}
+	case !client.Allows(grantType):
+		err = &oauthError{"unauthorized_client", "this client cannot use the " + grantType + " grant"}
+	case grantType == models.GrantAuthorizationCode:
+		resp, err = h.exchangeAuthorizationCode(c, client)
+	case grantType == models.GrantRefreshToken:
+		resp, err = h.refreshOAuthToken(c, client)
+	case grantType == models.GrantClientCredentials:
+		resp, err = h.clientCredentials(c, client)
+	}
+
+	var oerr *oauthError
+	if errors.As(err, &oerr) {
+		auditDetail(c, "error_description", oerr.Description)
+		c.JSON(http.StatusBadRequest, gin.H{"error": oerr.Code, "error_description": oerr.Description})
+		return
+	}
+	if err != nil {
+		log.Printf("oauth token request from client %s failed: %v", client.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// authenticateClient accepts client_secret_basic and client_secret_post for
+// confidential clients; public clients only send their client_id.
+func (h *AuthHandler) authenticateClient(c *gin.Context) (*models.OAuthClient, error) {
+	clientID, secret, basic := c.Request.BasicAuth()
+	if basic {
+		// RFC 6749 form-encodes the credentials before base64
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+
+	client, err := h.repo.FindOAuthClient(c.Request.Context(), clientID)
+	if err != nil {
+		return nil, errors.New("unknown client")
+	}
+
+	if client.Public() {
+		if secret != "" {
+			return nil, errors.New("public clients have no secret")
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(*client.SecretHash)) != 1 {
+		return nil, errors.New("invalid client credentials")
+	}
+	return client, nil
+}
+
+func (h *AuthHandler) exchangeAuthorizationCode(c *gin.Context, client *models.OAuthClient) (*models.OAuthTokenResponse, error) {
+	ctx := c.Request.Context()
+	codeHash := hashToken(c.PostForm("code"))
+
+	code, err := h.repo.ConsumeAuthorizationCode(ctx, codeHash)
+	if err != nil {
+		return nil, &oauthError{"invalid_grant", "invalid authorization code"}
+	}
+	auditSubject(c, code.UserID)
+
+	// A code used twice has leaked, so the session it was exchanged for goes too
+	if code.ConsumedAt != nil {
+		auditDetail(c, "code_replay", true)
+		if code.FamilyID != nil {
+			if err := h.repo.DeleteRefreshTokenFamily(ctx, *code.FamilyID); err != nil {
+				log.Printf("failed to revoke session of replayed authorization code: %v", err)
+			}
+		}
+		log.Printf("authorization code replayed by client %s for user %s", code.ClientID, code.UserID)
+		return nil, &oauthError{"invalid_grant", "invalid authorization code"}
+	}
+
+	if code.ClientID != client.ID || code.ExpiresAt.Before(time.Now()) {
+		return nil, &oauthError{"invalid_grant", "invalid authorization code"}
+	}
+	if c.PostForm("redirect_uri") != code.RedirectURI {
+		return nil, &oauthError{"invalid_grant", "redirect_uri does not match the authorization request"}
+	}
+	if !verifyCodeChallenge(c.PostForm("code_verifier"), code.CodeChallenge) {
+		return nil, &oauthError{"invalid_grant", "invalid code_verifier"}
+	}
+
+	user, err := h.repo.FindByID(ctx, code.UserID)
+	if err != nil || user.ClosedAt != nil || user.DisabledAt != nil {
+		return nil, &oauthError{"invalid_grant", "invalid authorization code"}
+	}
+
+	authn := models.Authentication{Time: code.AuthTime, Methods: code.AMR}
+	resp, familyID, err := h.issueOAuthTokens(c, client, user, code.Scope, "", authn, code.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	if familyID != "" {
+		if err := h.repo.SetAuthorizationCodeFamily(ctx, codeHash, familyID); err != nil {
+			log.Printf("failed to link authorization code to session %s: %v", familyID, err)
+		}
+	}
+	return resp, nil
+}
+
+// refreshOAuthToken rotates a third-party refresh token the same way
+// Refresh does for first-party sessions.
+func (h *AuthHandler) refreshOAuthToken(c *gin.Context, client *models.OAuthClient) (*models.OAuthTokenResponse, error) {
+	ctx := c.Request.Context()
+	tokenHash := hashToken(c.PostForm("refresh_token"))
+
+	stored, err := h.repo.FindRefreshToken(ctx, tokenHash)
+	if err != nil || stored.ClientID == nil || *stored.ClientID != client.ID {
+		return nil, &oauthError{"invalid_grant", "invalid or expired refresh token"}
+	}
+	auditSubject(c, stored.UserID)
+	if stored.RotatedAt != nil {
+		h.revokeReusedFamily(c, stored)
+		return nil, &oauthError{"invalid_grant", "invalid or expired refresh token"}
+	}
+
+	// Checked before rotating, so a stolen token can't burn the session
+	if !dpopBindingMatches(c, stored) {
+		return nil, &oauthError{"invalid_dpop_proof", "refresh token is bound to another DPoP key"}
+	}
+
+	user, err := h.repo.FindByID(ctx, stored.UserID)
+	if err != nil || user.DisabledAt != nil {
+		return nil, &oauthError{"invalid_grant", "invalid or expired refresh token"}
+	}
+
+	rotated, err := h.repo.RotateRefreshToken(ctx, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		h.revokeReusedFamily(c, stored)
+		return nil, &oauthError{"invalid_grant", "invalid or expired refresh token"}
+	}
+
+	resp, _, err := h.issueOAuthTokens(c, client, user, stored.Scope, stored.FamilyID, stored.Authentication(), "")
+	return resp, err
+}
+
+// clientCredentials issues a service token for the requested scopes, or for
+// every scope of the client when none are requested. Only confidential
+// clients get here: public ones cannot be registered for the grant.
+func (h *AuthHandler) clientCredentials(c *gin.Context, client *models.OAuthClient) (*models.OAuthTokenResponse, error) {
+	scopes := strings.Fields(c.PostForm("scope"))
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			return nil, &oauthError{"invalid_scope", "scope not allowed for this client: " + scope}
+		}
+	}
+
+	token, err := h.issueServiceToken(client, scopes)
+	if err != nil {
+		return nil, err
+	}
+	return &models.OAuthTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(serviceTokenExpiry.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+// issueServiceToken signs a token for a service client. It has no user:
+// the subject is the client, and the audience names the services its
+// scopes are for, so a token for one service is refused by the others.
+func (h *AuthHandler) issueServiceToken(client *models.OAuthClient, scopes []string) (string, error) {
+	var audience jwt.ClaimStrings
+	for _, scope := range scopes {
+		if aud, ok := models.ServiceScopes[scope]; ok && !slices.Contains(audience, aud) {
+			audience = append(audience, aud)
+		}
+	}
+
+	tokenID, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return h.keys.Sign(&middleware.Claims{
+		ClientID: client.ID,
+		Scope:    strings.Join(scopes, " "),
+		TokenUse: middleware.TokenUseAccess,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Issuer:    h.issuer,
+			Subject:   client.ID,
+			Audience:  audience,
+			ExpiresAt: jwt.NewNumericDate(now.Add(serviceTokenExpiry)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	})
+}
+
+// issueOAuthTokens issues the tokens for scope. A refresh token, and with it
+// a session listed to the user, only comes with offline_access; the ID token
+// only with openid. It returns the session's family ID, if any.
+func (h *AuthHandler) issueOAuthTokens(c *gin.Context, client *models.OAuthClient, user *models.User, scope, familyID string, authn models.Authentication, nonce string) (*models.OAuthTokenResponse, string, error) {
+	scopes := strings.Fields(scope)
+	resp := &models.OAuthTokenResponse{TokenType: tokenType(c), ExpiresIn: int((15 * time.Minute).Seconds()), Scope: scope}
+
+	if slices.Contains(scopes, "offline_access") {
+		// Third-party refresh tokens are opaque: clients can't present them
+		// anywhere but the token endpoint, which looks them up by hash
+		refreshToken, err := randomToken(32)
+		if err != nil {
+			return nil, "", err
+		}
+		familyID, err = h.repo.StoreRefreshToken(c.Request.Context(), &models.RefreshToken{
+			UserID:    user.ID,
+			FamilyID:  familyID,
+			AuthTime:  authn.Time,
+			AMR:       authn.Methods,
+			ClientID:  &client.ID,
+			Scope:     scope,
+			DPoPJKT:   dpopJKT(c),
+			ExpiresAt: time.Now().Add(7 * 24 * time.Hour),
+		}, hashToken(refreshToken), clientInfo(c))
+		if err != nil {
+			return nil, "", err
+		}
+		resp.RefreshToken = refreshToken
+	}
+
+	_, userPermissions, err := h.repo.UserAccess(c.Request.Context(), user.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	var permissions []string
+	for _, s := range scopes {
+		if permission, ok := models.ScopePermissions[s]; ok && slices.Contains(userPermissions, permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+
+	tokenID, err := randomToken(16)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	// Third-party tokens carry no roles, only the consented scopes and the
+	// permissions those need, which payment-service enforces per route
+	accessToken, err := h.keys.Sign(&middleware.Claims{
+		UserID:        user.ID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		AuthTime:      jwt.NewNumericDate(authn.Time),
+		AMR:           authn.Methods,
+		ACR:           authn.ACR(),
+		SessionID:     familyID,
+		ClientID:      client.ID,
+		Scope:         scope,
+		Permissions:   permissions,
+		Confirmation:  confirmation(c.GetString("dpop_jkt")),
+		TokenUse:      middleware.TokenUseAccess,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Issuer:    h.issuer,
+			Subject:   user.ID,
+			ExpiresAt: jwt.NewNumericDate(now.Add(15 * time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	resp.AccessToken = accessToken
+
+	if slices.Contains(scopes, "openid") {
+		info := userInfo(user, scopes)
+		resp.IDToken, err = h.keys.Sign(&IDTokenClaims{
+			Nonce:         nonce,
+			AuthTime:      jwt.NewNumericDate(authn.Time),
+			AMR:           authn.Methods,
+			ACR:           authn.ACR(),
+			Name:          info.Name,
+			Email:         info.Email,
+			EmailVerified: info.EmailVerified,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    h.issuer,
+				Subject:   user.ID,
+				Audience:  jwt.ClaimStrings{client.ID},
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+				IssuedAt:  jwt.NewNumericDate(now),
+			},
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return resp, familyID, nil
+}
+
+// UserInfo returns the claims about the user that the token's scopes
+// release. It must run after OAuthAuth with the openid scope.
+func (h *AuthHandler) UserInfo(c *gin.Context) {
+	user, err := h.repo.FindByID(c.Request.Context(), c.GetString("user_id"))
+	if err != nil || user.ClosedAt != nil || user.DisabledAt != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+		return
+	}
+	c.JSON(http.StatusOK, userInfo(user, c.GetStringSlice("scopes")))
+}
+
+func userInfo(user *models.User, scopes []string) models.UserInfo {
+	info := models.UserInfo{Subject: user.ID}
+	if slices.Contains(scopes, "profile") {
+		info.Name = user.Name
+	}
+	if slices.Contains(scopes, "email") {
+		verified := user.EmailVerifiedAt != nil
+		info.Email = user.Email
+		info.EmailVerified = &verified
+	}
+	return info
+}
+
+// redirectURL adds params to a registered redirect URI, keeping its own
+// query, plus the RFC 9207 iss parameter that lets clients detect mix-up
+// attacks. Empty values are left out.
+func (h *AuthHandler) redirectURL(redirectURI string, params url.Values) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := u.Query()
+	for k, v := range params {
+		if len(v) > 0 && v[0] != "" {
+			query[k] = v
+		}
+	}
+	query.Set("iss", h.issuer)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// verifyCodeChallenge checks an RFC 7636 S256 code verifier.
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
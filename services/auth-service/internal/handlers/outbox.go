@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dogpay/auth-service/internal/models"
+)
+
+const (
+	outboxBatchSize = 20
+	// A claimed event is retried by any relay once its lease runs out, in
+	// case the one that claimed it stopped mid-delivery
+	outboxLease      = time.Minute
+	outboxMaxBackoff = 10 * time.Minute
+)
+
+// RunOutboxRelay delivers auth.outbox_events to payment service every
+// interval, and right away when a handler writes a new event. Failed
+// deliveries are retried with exponential backoff until acknowledged, except
+// events payment service rejects for good, which are marked failed.
+func (h *AuthHandler) RunOutboxRelay(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.relayOutbox(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-h.outboxWake:
+		}
+	}
+}
+
+// wakeOutbox asks the relay to run now instead of at its next tick.
+func (h *AuthHandler) wakeOutbox() {
+	select {
+	case h.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+func (h *AuthHandler) relayOutbox(ctx context.Context) {
+	for {
+		events, err := h.repo.ClaimOutboxEvents(ctx, outboxBatchSize, outboxLease)
+		if err != nil {
+			log.Printf("failed to claim outbox events: %v", err)
+			return
+		}
+		for _, event := range events {
+			h.deliverOutboxEvent(ctx, event)
+		}
+		if len(events) < outboxBatchSize {
+			return
+		}
+	}
+}
+
+func (h *AuthHandler) deliverOutboxEvent(ctx context.Context, event models.OutboxEvent) {
+	err := h.callPaymentService(ctx, http.MethodPost, "/internal/events", event, nil)
+	if err == nil {
+		if err := h.repo.MarkOutboxEventDelivered(ctx, event.ID); err != nil {
+			// The receiver deduplicates, so sending it again is harmless
+			log.Printf("failed to mark outbox event %s delivered: %v", event.ID, err)
+		}
+		return
+	}
+
+	var rejected *paymentServiceError
+	if errors.As(err, &rejected) && permanentRejection(rejected.status) {
+		log.Printf("payment service rejected %s event %s, not retrying: %v", event.Type, event.ID, err)
+		if err := h.repo.FailOutboxEvent(ctx, event.ID, err.Error()); err != nil {
+			log.Printf("failed to mark outbox event %s failed: %v", event.ID, err)
+		}
+		return
+	}
+
+	delay := outboxBackoff(event.Attempts)
+	log.Printf("failed to deliver %s event %s (attempt %d, next in %s): %v", event.Type, event.ID, event.Attempts, delay, err)
+	if err := h.repo.RetryOutboxEvent(ctx, event.ID, time.Now().Add(delay), err.Error()); err != nil {
+		log.Printf("failed to reschedule outbox event %s: %v", event.ID, err)
+	}
+}
+
+// permanentRejection tells client errors that another attempt can't fix
+// from those that pass: a service token not yet trusted after a key
+// rotation, a timeout or rate limiting.
+func permanentRejection(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return status >= 400 && status < 500
+}
+
+// outboxBackoff doubles the wait after each failed attempt, from one second
+// up to outboxMaxBackoff.
+func outboxBackoff(attempts int) time.Duration {
+	delay := time.Second << min(max(attempts-1, 0), 10)
+	return min(delay, outboxMaxBackoff)
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dogpay/auth-service/internal/mailer"
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+const passwordResetExpiry = 30 * time.Minute
+
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	auditDetail(c, "email", req.Email)
+
+	// Same response whether or not the account exists
+	accepted := gin.H{"message": "if the email is registered, a reset link has been sent"}
+
+	user, err := h.repo.FindByEmail(c.Request.Context(), req.Email)
+	if err != nil {
+		c.JSON(http.StatusAccepted, accepted)
+		return
+	}
+	auditSubject(c, user.ID)
+
+	token, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate reset token"})
+		return
+	}
+
+	expiresAt := time.Now().Add(passwordResetExpiry)
+	if err := h.repo.CreateUserToken(c.Request.Context(), user.ID, models.TokenPurposePasswordReset, hashToken(token), expiresAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store reset token"})
+		return
+	}
+
+	// Sent in the background so response time does not reveal the account exists
+	go h.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Redefinição de senha do DogPay",
+		Body: fmt.Sprintf(
+			"Olá, %s!\n\nRecebemos um pedido para redefinir a senha da sua conta DogPay.\n"+
+				"Use o link abaixo em até %d minutos:\n\n%s\n\n"+
+				"Se você não pediu a redefinição, ignore este email. Sua senha continua a mesma.\n",
+			user.Name, int(passwordResetExpiry.Minutes()), h.appLink("/reset-password", token),
+		),
+	})
+
+	c.JSON(http.StatusAccepted, accepted)
+}
+
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.repo.FindUserByToken(c.Request.Context(), models.TokenPurposePasswordReset, hashToken(req.Token))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
+		return
+	}
+	auditSubject(c, user.ID)
+
+	if !h.enforcePolicy(c, req.Password, user.Email, user.Name) {
+		return
+	}
+
+	hash, err := h.passwords.Hash(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+		return
+	}
+
+	if _, err := h.repo.ResetPassword(c.Request.Context(), hashToken(req.Token), hash); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ChangePassword replaces the password of the current user after checking
+// the current one. Every other session is signed out.
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.repo.FindByID(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	if !h.checkPassword(c, user, req.CurrentPassword) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "current password is incorrect"})
+		return
+	}
+
+	if !h.enforcePolicy(c, req.NewPassword, user.Email, user.Name) {
+		return
+	}
+
+	hash, err := h.passwords.Hash(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+		return
+	}
+
+	if err := h.repo.ChangePassword(c.Request.Context(), user.ID, hash, c.GetString("session_id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to change password"})
+		return
+	}
+
+	err = h.repo.RecordSecurityEvent(c.Request.Context(), user.ID, "password_changed", map[string]interface{}{
+		"ip":         c.ClientIP(),
+		"user_agent": c.Request.UserAgent(),
+	})
+	if err != nil {
+		log.Printf("failed to record password change for user %s: %v", user.ID, err)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// enforcePolicy checks a new password against the policy and, when it is
+// rejected, responds with the reasons under fields.password.
+func (h *AuthHandler) enforcePolicy(c *gin.Context, plaintext string, context ...string) bool {
+	problems := h.policy.Check(plaintext, context...)
+	if len(problems) == 0 {
+		return true
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":  "password does not meet the password policy",
+		"fields": gin.H{"password": problems},
+	})
+	return false
+}
+
+// checkPassword verifies plaintext against the stored hash of user. When
+// the hash uses an outdated algorithm or parameters it is transparently
+// replaced with a fresh one.
+func (h *AuthHandler) checkPassword(c *gin.Context, user *models.User, plaintext string) bool {
+	ok, needsRehash, err := h.passwords.Verify(user.PasswordHash, plaintext)
+	if err != nil {
+		log.Printf("failed to verify password of user %s: %v", user.ID, err)
+		return false
+	}
+
+	if ok && needsRehash {
+		hash, err := h.passwords.Hash(plaintext)
+		if err == nil {
+			err = h.repo.UpdatePasswordHash(c.Request.Context(), user.ID, user.PasswordHash, hash)
+		}
+		if err != nil {
+			log.Printf("failed to rehash password of user %s: %v", user.ID, err)
+		}
+	}
+	return ok
+}
+
+func (h *AuthHandler) sendMail(msg mailer.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := h.mailer.Send(ctx, msg); err != nil {
+		log.Printf("failed to send %q to %s: %v", msg.Subject, msg.To, err)
+	}
+}
+
+// appLink builds a link into the web app carrying token as a query parameter.
+func (h *AuthHandler) appLink(path, token string) string {
+	return h.appURL + path + "?token=" + url.QueryEscape(token)
+}
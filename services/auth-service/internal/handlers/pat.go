@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// CreatePersonalAccessToken issues a long-lived token for scripts. The
+// token is returned once; only its hash is stored.
+func (h *AuthHandler) CreatePersonalAccessToken(c *gin.Context) {
+	var req models.CreatePersonalAccessTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, scope := range req.Scopes {
+		if !slices.Contains(models.PersonalAccessTokenScopes, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown scope: " + scope})
+			return
+		}
+	}
+	slices.Sort(req.Scopes)
+	scopes := slices.Compact(req.Scopes)
+
+	secret, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+	token := models.PersonalAccessTokenPrefix + secret
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	pat, err := h.repo.CreatePersonalAccessToken(
+		c.Request.Context(), c.GetString("user_id"), req.Name,
+		hashToken(token), token[:len(models.PersonalAccessTokenPrefix)+4], scopes, expiresAt,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.CreatePersonalAccessTokenResponse{PersonalAccessToken: *pat, Token: token})
+}
+
+func (h *AuthHandler) ListPersonalAccessTokens(c *gin.Context) {
+	pats, err := h.repo.ListPersonalAccessTokens(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list tokens"})
+		return
+	}
+	c.JSON(http.StatusOK, pats)
+}
+
+func (h *AuthHandler) RevokePersonalAccessToken(c *gin.Context) {
+	deleted, err := h.repo.DeletePersonalAccessToken(c.Request.Context(), c.GetString("user_id"), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke token"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var paymentClient = &http.Client{Timeout: 10 * time.Second}
+
+func paymentServiceURL() string {
+	if url := os.Getenv("PAYMENT_SERVICE_URL"); url != "" {
+		return url
+	}
+	return "http://payment-service:8002"
+}
+
+// paymentServiceError carries a rejection from payment service, so it can
+// be passed on to the client.
+type paymentServiceError struct {
+	status  int
+	message string
+}
+
+func (e *paymentServiceError) Error() string {
+	return fmt.Sprintf("payment service responded %d: %s", e.status, e.message)
+}
+
+// selfClientID is the service client auth-service calls other services as.
+// Its tokens are issued directly rather than through the token endpoint.
+const selfClientID = "auth-service"
+
+// serviceTokenCache keeps auth-service's own service token until shortly
+// before it expires.
+type serviceTokenCache struct {
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// serviceToken returns a client-credentials token for selfClientID with the
+// scopes registered for it.
+func (h *AuthHandler) serviceToken(ctx context.Context) (string, error) {
+	h.serviceTokens.mu.Lock()
+	defer h.serviceTokens.mu.Unlock()
+
+	if time.Until(h.serviceTokens.expiresAt) > time.Minute {
+		return h.serviceTokens.token, nil
+	}
+
+	client, err := h.repo.FindOAuthClient(ctx, selfClientID)
+	if err != nil {
+		return "", err
+	}
+	token, err := h.issueServiceToken(client, client.Scopes)
+	if err != nil {
+		return "", err
+	}
+	h.serviceTokens.token = token
+	h.serviceTokens.expiresAt = time.Now().Add(serviceTokenExpiry)
+	return token, nil
+}
+
+// callPaymentService sends an internal request to payment service and
+// decodes a successful response into out, when out is not nil.
+func (h *AuthHandler) callPaymentService(ctx context.Context, method, path string, body, out interface{}) error {
+	token, err := h.serviceToken(ctx)
+	if err != nil {
+		return fmt.Errorf("issue service token: %w", err)
+	}
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, paymentServiceURL()+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := paymentClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var payload struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&payload)
+		return &paymentServiceError{status: resp.StatusCode, message: payload.Error}
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
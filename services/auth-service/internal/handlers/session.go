@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// ListSessions returns the signed-in devices of the current user and marks
+// the one making the request.
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	sessions, err := h.repo.ListSessions(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list sessions"})
+		return
+	}
+
+	current := c.GetString("session_id")
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession signs one device out. Its refresh token stops working and
+// introspection reports its access tokens as inactive.
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	userID := c.GetString("user_id")
+	sessionID := c.Param("id")
+
+	deleted, err := h.repo.DeleteSession(c.Request.Context(), userID, sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke session"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	err = h.repo.RecordSecurityEvent(c.Request.Context(), userID, "session_revoked", map[string]interface{}{
+		"family_id": sessionID,
+		"ip":        c.ClientIP(),
+	})
+	if err != nil {
+		log.Printf("failed to record session revocation for user %s: %v", userID, err)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// clientInfo describes the device behind the request. Clients identify
+// themselves with the X-Client-Type header; anything else is unknown.
+func clientInfo(c *gin.Context) models.ClientInfo {
+	clientType := strings.ToLower(strings.TrimSpace(c.GetHeader("X-Client-Type")))
+	if clientType != models.ClientTypeWeb && clientType != models.ClientTypeMobile {
+		clientType = models.ClientTypeUnknown
+	}
+
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+
+	return models.ClientInfo{
+		UserAgent:  userAgent,
+		IPAddress:  c.ClientIP(),
+		ClientType: clientType,
+	}
+}
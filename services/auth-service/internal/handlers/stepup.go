@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// StepUp re-authenticates the current user and returns an access token with
+// a fresh auth_time. The session keeps its original authentication, so
+// refreshed tokens do not inherit the step-up.
+func (h *AuthHandler) StepUp(c *gin.Context) {
+	var req models.StepUpRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !h.allowLoginFromIP(c) {
+		return
+	}
+
+	user, err := h.repo.FindByID(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if user.DisabledAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account disabled"})
+		return
+	}
+	if h.refuseLocked(c, user) {
+		return
+	}
+
+	if req.Password == "" || !h.checkPassword(c, user, req.Password) {
+		auditDetail(c, "wrong_password", true)
+		h.recordLoginFailure(c, user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		return
+	}
+
+	authn := models.NewAuthentication(models.AMRPassword)
+	if user.TOTPEnabledAt != nil {
+		ok, err := h.verifySecondFactor(c, user, req.Code, "")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify code"})
+			return
+		}
+		if !ok {
+			auditDetail(c, "wrong_code", true)
+			h.recordLoginFailure(c, user)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+			return
+		}
+		authn = models.NewAuthentication(models.AMRPassword, models.AMROTP, models.AMRMultiFactor)
+	}
+	h.clearLoginFailures(c, user)
+
+	// A DPoP-bound session gets a token bound to the same key, which JWTAuth
+	// checked the proof against
+	accessToken, err := h.generateAccessToken(c.Request.Context(), user, c.GetString("session_id"), authn, c.GetString("dpop_jkt"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.StepUpResponse{AccessToken: accessToken})
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	loginIPLimit  = 20
+	loginIPWindow = time.Minute
+
+	// After lockoutThreshold consecutive failures the account is locked for
+	// lockoutBase, doubling with every further failure up to lockoutMax.
+	lockoutThreshold = 5
+	lockoutBase      = time.Minute
+	lockoutMax       = time.Hour
+	// A failure streak older than this starts over
+	failureStreakReset = 24 * time.Hour
+)
+
+// allowLoginFromIP enforces the per-IP login rate limit. Database errors
+// fail open: losing the limiter should not take logins down with it.
+func (h *AuthHandler) allowLoginFromIP(c *gin.Context) bool {
+	count, err := h.repo.HitRateLimit(c.Request.Context(), "login:ip:"+c.ClientIP(), loginIPWindow)
+	if err != nil {
+		log.Printf("login rate limit unavailable: %v", err)
+		return true
+	}
+
+	if count > loginIPLimit {
+		c.Header("Retry-After", fmt.Sprint(int(loginIPWindow.Seconds())))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many login attempts, try again later"})
+		return false
+	}
+	return true
+}
+
+// recordLoginFailure extends the failure streak of user and locks the
+// account once the streak reaches the threshold.
+func (h *AuthHandler) recordLoginFailure(c *gin.Context, user *models.User) {
+	ctx := c.Request.Context()
+
+	failures, err := h.repo.RecordLoginFailure(ctx, user.ID, failureStreakReset)
+	if err != nil {
+		log.Printf("failed to record login failure for user %s: %v", user.ID, err)
+		return
+	}
+	if failures < lockoutThreshold {
+		return
+	}
+
+	duration := lockoutDuration(failures)
+	if err := h.repo.LockUser(ctx, user.ID, time.Now().Add(duration)); err != nil {
+		log.Printf("failed to lock user %s: %v", user.ID, err)
+		return
+	}
+
+	err = h.repo.RecordSecurityEvent(ctx, user.ID, "account_locked", map[string]interface{}{
+		"failures":   failures,
+		"duration":   duration.String(),
+		"ip":         c.ClientIP(),
+		"user_agent": c.Request.UserAgent(),
+	})
+	if err != nil {
+		log.Printf("failed to record lockout of user %s: %v", user.ID, err)
+	}
+}
+
+// refuseLocked answers a signed-in user who re-enters a password or code
+// while their account is locked. Wrong answers there count toward the same
+// lockout as logins, or a stolen access token could guess them freely.
+func (h *AuthHandler) refuseLocked(c *gin.Context, user *models.User) bool {
+	if !user.IsLocked() {
+		return false
+	}
+	auditDetail(c, "locked", true)
+	c.Header("Retry-After", fmt.Sprint(int(time.Until(*user.LockedUntil).Seconds())+1))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed attempts, try again later"})
+	return true
+}
+
+// clearLoginFailures ends the failure streak of user after a complete
+// login, with every factor verified.
+func (h *AuthHandler) clearLoginFailures(c *gin.Context, user *models.User) {
+	if user.FailedLoginCount == 0 {
+		return
+	}
+	if err := h.repo.UnlockUser(c.Request.Context(), user.ID); err != nil {
+		log.Printf("failed to reset login failures for user %s: %v", user.ID, err)
+	}
+}
+
+func lockoutDuration(failures int) time.Duration {
+	exp := failures - lockoutThreshold
+	if exp > 10 {
+		return lockoutMax
+	}
+	d := lockoutBase * time.Duration(math.Pow(2, float64(exp)))
+	if d > lockoutMax {
+		return lockoutMax
+	}
+	return d
+}
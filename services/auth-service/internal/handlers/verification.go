@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dogpay/auth-service/internal/mailer"
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+const emailVerificationExpiry = 24 * time.Hour
+
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req models.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.repo.VerifyEmail(c.Request.Context(), hashToken(req.Token))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired verification token"})
+		return
+	}
+	auditSubject(c, user.ID)
+
+	// Tokens issued before verification still say email_verified=false;
+	// clients pick up the new claim on their next refresh.
+	c.JSON(http.StatusOK, user)
+}
+
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	user, err := h.repo.FindByID(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	if user.EmailVerifiedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "email already verified"})
+		return
+	}
+
+	if err := h.sendVerificationEmail(c, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "verification email sent"})
+}
+
+func (h *AuthHandler) sendVerificationEmail(c *gin.Context, user *models.User) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(emailVerificationExpiry)
+	if err := h.repo.CreateUserToken(c.Request.Context(), user.ID, models.TokenPurposeEmailVerification, hashToken(token), expiresAt); err != nil {
+		return err
+	}
+
+	go h.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Confirme seu email no DogPay",
+		Body: fmt.Sprintf(
+			"Olá, %s!\n\nConfirme seu email para liberar transferências na sua conta DogPay:\n\n%s\n\n"+
+				"O link expira em 24 horas. Se você não criou uma conta, ignore este email.\n",
+			user.Name, h.appLink("/verify-email", token),
+		),
+	})
+	return nil
+}
@@ -0,0 +1,254 @@
+package keys
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/dogpay/auth-service/internal/repository"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Keyring holds the Ed25519 keys stored in auth.signing_keys. Tokens are
+// signed with the newest active key whose activation time has passed and
+// carry its kid; verifiers pick the key by kid, so keys can be swapped while
+// tokens signed with the previous one are still in flight.
+type Keyring struct {
+	repo      *repository.KeyRepository
+	retention time.Duration
+
+	mu   sync.RWMutex
+	keys []*key
+}
+
+type key struct {
+	kid         string
+	status      string
+	activatesAt time.Time
+	private     ed25519.PrivateKey
+}
+
+// NewKeyring creates an empty keyring. retention is how long a superseded
+// key keeps verifying tokens and must cover the longest token lifetime.
+func NewKeyring(repo *repository.KeyRepository, retention time.Duration) *Keyring {
+	return &Keyring{repo: repo, retention: retention}
+}
+
+// Bootstrap makes sure the keyring has a key to sign with. On an empty
+// keyring the PEM key at importPath is imported, or a new key is generated
+// when importPath is empty.
+func (k *Keyring) Bootstrap(ctx context.Context, importPath string) error {
+	stored, err := k.repo.List(ctx, false)
+	if err != nil {
+		return err
+	}
+	for _, s := range stored {
+		if s.Status == "active" {
+			return k.Reload(ctx)
+		}
+	}
+
+	var priv ed25519.PrivateKey
+	if importPath != "" {
+		if priv, err = readPrivateKey(importPath); err != nil {
+			return err
+		}
+		log.Printf("Importing signing key from %s", importPath)
+	} else {
+		if _, priv, err = ed25519.GenerateKey(rand.Reader); err != nil {
+			return fmt.Errorf("generate signing key: %w", err)
+		}
+		log.Println("Keyring is empty, generated a new signing key")
+	}
+
+	if _, err := k.store(ctx, priv, time.Now()); err != nil {
+		return err
+	}
+	return k.Reload(ctx)
+}
+
+// Rotate adds a new key that starts signing after activateIn. Until then it
+// is only published, giving verifiers time to pick it up; the current key
+// then retires once retention has passed.
+func (k *Keyring) Rotate(ctx context.Context, activateIn time.Duration) (*models.SigningKey, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate signing key: %w", err)
+	}
+	return k.store(ctx, priv, time.Now().Add(activateIn))
+}
+
+func (k *Keyring) store(ctx context.Context, priv ed25519.PrivateKey, activatesAt time.Time) (*models.SigningKey, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("marshal signing key: %w", err)
+	}
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	return k.repo.Create(ctx, Thumbprint(priv.Public().(ed25519.PublicKey)), string(encoded), activatesAt)
+}
+
+// List returns every key including retired ones, newest first.
+func (k *Keyring) List(ctx context.Context) ([]models.SigningKey, error) {
+	if err := k.repo.Advance(ctx, k.retention); err != nil {
+		return nil, err
+	}
+	return k.repo.List(ctx, true)
+}
+
+// Reload advances key states and reloads every non-retired key.
+func (k *Keyring) Reload(ctx context.Context) error {
+	if err := k.repo.Advance(ctx, k.retention); err != nil {
+		return err
+	}
+
+	stored, err := k.repo.List(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	loaded := make([]*key, 0, len(stored))
+	for _, s := range stored {
+		priv, err := parsePrivateKey([]byte(s.PrivateKey))
+		if err != nil {
+			log.Printf("skipping signing key %s: %v", s.KID, err)
+			continue
+		}
+		loaded = append(loaded, &key{kid: s.KID, status: s.Status, activatesAt: s.ActivatesAt, private: priv})
+	}
+
+	k.mu.Lock()
+	k.keys = loaded
+	k.mu.Unlock()
+	return nil
+}
+
+// Run reloads the keyring every interval until ctx is cancelled, so keys
+// rotated by another instance or the admin command are picked up.
+func (k *Keyring) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := k.Reload(ctx); err != nil {
+				log.Printf("failed to reload keyring: %v", err)
+			}
+		}
+	}
+}
+
+// Sign signs claims with the current signing key and sets its kid header.
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	signing := k.signingKey()
+	if signing == nil {
+		return "", fmt.Errorf("no active signing key")
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = signing.kid
+	return token.SignedString(signing.private)
+}
+
+func (k *Keyring) signingKey() *key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+	var signing *key
+	for _, key := range k.keys {
+		if key.status != "active" || key.activatesAt.After(now) {
+			continue
+		}
+		if signing == nil || key.activatesAt.After(signing.activatesAt) {
+			signing = key
+		}
+	}
+	return signing
+}
+
+// PublicKey returns the verification key for kid. Keys that have not
+// activated yet are included: another instance may already sign with them.
+func (k *Keyring) PublicKey(kid string) (ed25519.PublicKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, key := range k.keys {
+		if key.kid == kid {
+			return key.private.Public().(ed25519.PublicKey), true
+		}
+	}
+	return nil, false
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes every active and retiring key.
+func (k *Keyring) JWKS() JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range k.keys {
+		set.Keys = append(set.Keys, JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(key.private.Public().(ed25519.PublicKey)),
+			Kid: key.kid,
+			Use: "sig",
+			Alg: "EdDSA",
+		})
+	}
+	return set
+}
+
+// Thumbprint computes the RFC 7638 JWK thumbprint used as kid.
+func Thumbprint(pub ed25519.PublicKey) string {
+	canonical := fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`, base64.RawURLEncoding.EncodeToString(pub))
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func readPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read signing key: %w", err)
+	}
+	return parsePrivateKey(data)
+}
+
+func parsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	parsed, err := jwt.ParseEdPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse signing key: %w", err)
+	}
+
+	priv, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("parse signing key: not an Ed25519 key")
+	}
+	return priv, nil
+}
@@ -0,0 +1,94 @@
+package mailer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional emails such as password reset links.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type SMTPMailer struct {
+	addr     string
+	from     string
+	envelope string
+	auth     smtp.Auth
+}
+
+// NewSMTPMailer sends through an SMTP relay. Authentication is skipped when
+// username is empty, which is what local relays like MailHog expect.
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	m := &SMTPMailer{addr: host + ":" + port, from: from, envelope: from}
+	// The envelope sender must be a bare address, while From may carry a name
+	if addr, err := mail.ParseAddress(from); err == nil {
+		m.envelope = addr.Address
+	}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := smtp.SendMail(m.addr, m.auth, m.envelope, []string{msg.To}, render(m.from, msg)); err != nil {
+		return fmt.Errorf("send mail: %w", err)
+	}
+	return nil
+}
+
+// OutboxMailer writes every message as an .eml file into a directory
+// instead of sending it. It is meant for development and tests.
+type OutboxMailer struct {
+	dir  string
+	from string
+}
+
+func NewOutboxMailer(dir, from string) (*OutboxMailer, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create outbox dir: %w", err)
+	}
+	return &OutboxMailer{dir: dir, from: from}, nil
+}
+
+func (m *OutboxMailer) Send(ctx context.Context, msg Message) error {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000Z"), hex.EncodeToString(suffix))
+
+	if err := os.WriteFile(filepath.Join(m.dir, name), render(m.from, msg), 0o640); err != nil {
+		return fmt.Errorf("write outbox message: %w", err)
+	}
+	return nil
+}
+
+func render(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
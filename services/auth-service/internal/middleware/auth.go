@@ -1,48 +1,184 @@
 package middleware
 
 import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type Claims struct {
-	UserID string `json:"user_id"`
-	Email  string `json:"email"`
+	UserID        string           `json:"user_id"`
+	Email         string           `json:"email"`
+	EmailVerified bool             `json:"email_verified"`
+	AuthTime      *jwt.NumericDate `json:"auth_time,omitempty"`
+	AMR           []string         `json:"amr,omitempty"`
+	ACR           string           `json:"acr,omitempty"`
+	Roles         []string         `json:"roles,omitempty"`
+	Permissions   []string         `json:"perms,omitempty"`
+	ClientID      string           `json:"client_id,omitempty"`
+	Scope         string           `json:"scope,omitempty"`
+	SessionID     string           `json:"sid,omitempty"`
+	Confirmation  *Confirmation    `json:"cnf,omitempty"`
+	TokenUse      string           `json:"token_use,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func JWTAuth(jwtSecret string) gin.HandlerFunc {
+// Values of the token_use claim. Refresh tokens are signed with the same
+// keys as access tokens, so only tokens marked as access tokens authenticate
+// requests.
+const (
+	TokenUseAccess  = "access"
+	TokenUseRefresh = "refresh"
+)
+
+// KeyLookup resolves the verification key named by a token's kid header.
+type KeyLookup interface {
+	PublicKey(kid string) (ed25519.PublicKey, bool)
+}
+
+// RevocationStore tells whether an access token was revoked before it
+// expired: by logout, for all of its user's tokens, or with its session.
+type RevocationStore interface {
+	AccessTokenActive(ctx context.Context, jti string, userID, sessionID *string, issuedAt time.Time) (bool, error)
+}
+
+// TokenActive checks the claims of a valid access token against store.
+func TokenActive(ctx context.Context, store RevocationStore, claims *Claims) (bool, error) {
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return false, nil
+	}
+	var userID, sessionID *string
+	if claims.UserID != "" {
+		userID = &claims.UserID
+	}
+	if claims.SessionID != "" {
+		sessionID = &claims.SessionID
+	}
+	return store.AccessTokenActive(ctx, claims.ID, userID, sessionID, claims.IssuedAt.Time)
+}
+
+// JWTAuth authenticates first-party access tokens, with a DPoP proof when
+// they are DPoP-bound, that have not been revoked. Tokens issued to
+// third-party OAuth clients are rejected: they only reach the APIs their
+// scopes grant, through OAuthAuth or payment service.
+func JWTAuth(keys KeyLookup, dpop *DPoPVerifier, revocations RevocationStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authorization header required"})
+		claims, ok := parseBearer(c, keys, dpop, revocations)
+		if !ok {
 			return
 		}
-
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid authorization format"})
+		if claims.ClientID != "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "token was issued to a third-party client"})
 			return
 		}
 
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(parts[1], claims, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return []byte(jwtSecret), nil
-		})
+		setClaims(c, claims)
+		c.Next()
+	}
+}
 
-		if err != nil || !token.Valid {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+// OAuthAuth authenticates access tokens issued to OAuth clients and
+// requires scope among their granted scopes.
+func OAuthAuth(keys KeyLookup, dpop *DPoPVerifier, revocations RevocationStore, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := parseBearer(c, keys, dpop, revocations)
+		if !ok {
+			return
+		}
+		if claims.ClientID == "" || !slices.Contains(strings.Fields(claims.Scope), scope) {
+			c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient_scope"})
 			return
 		}
 
-		c.Set("user_id", claims.UserID)
-		c.Set("email", claims.Email)
+		setClaims(c, claims)
+		c.Set("client_id", claims.ClientID)
+		c.Set("scopes", strings.Fields(claims.Scope))
 		c.Next()
 	}
 }
+
+// parseBearer reads the access token of a Bearer or DPoP authorization
+// header and makes sure it was not revoked.
+func parseBearer(c *gin.Context, keys KeyLookup, dpop *DPoPVerifier, revocations RevocationStore) (*Claims, bool) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authorization header required"})
+		return nil, false
+	}
+
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || (parts[0] != "Bearer" && parts[0] != "DPoP") {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid authorization format"})
+		return nil, false
+	}
+
+	claims, err := ParseToken(keys, parts[1])
+	if err != nil || !claims.IsAccessToken() {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+		return nil, false
+	}
+	if !checkDPoP(c, dpop, parts[0], parts[1], claims) {
+		return nil, false
+	}
+
+	active, err := TokenActive(c.Request.Context(), revocations, claims)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "unable to verify token"})
+		return nil, false
+	}
+	if !active {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token has been revoked"})
+		return nil, false
+	}
+	return claims, true
+}
+
+// ParseToken verifies a token signed by the keyring and returns its claims.
+func ParseToken(keys KeyLookup, raw string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodEd25519); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		kid, _ := t.Header["kid"].(string)
+		key, ok := keys.PublicKey(kid)
+		if !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+// IsAccessToken tells access tokens from refresh tokens and ID tokens,
+// which are signed with the same keys.
+func (c *Claims) IsAccessToken() bool {
+	return c.TokenUse == TokenUseAccess
+}
+
+func setClaims(c *gin.Context, claims *Claims) {
+	c.Set("user_id", claims.UserID)
+	c.Set("email", claims.Email)
+	c.Set("email_verified", claims.EmailVerified)
+	c.Set("roles", claims.Roles)
+	c.Set("permissions", claims.Permissions)
+	c.Set("session_id", claims.SessionID)
+	c.Set("amr", claims.AMR)
+	if claims.AuthTime != nil {
+		c.Set("auth_time", claims.AuthTime.Time)
+	}
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole lets the request through when the token carries any of the
+// given roles. It must run after JWTAuth.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		held := c.GetStringSlice("roles")
+		for _, role := range roles {
+			if slices.Contains(held, role) {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient role"})
+	}
+}
+
+// RequirePermission lets the request through when the token carries every
+// given permission. It must run after JWTAuth.
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		held := c.GetStringSlice("permissions")
+		for _, permission := range permissions {
+			if !slices.Contains(held, permission) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"error":      "insufficient permissions",
+					"permission": permission,
+				})
+				return
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRecentAuth guards operations that mint long-lived credentials.
+// The token's auth_time must be at most maxAge old and its amr must contain
+// one of methods; otherwise the client re-authenticates via /auth/step-up,
+// the same way payment service asks for large transfers.
+func RequireRecentAuth(maxAge time.Duration, methods ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authTime := c.GetTime("auth_time")
+		fresh := !authTime.IsZero() && time.Since(authTime) <= maxAge
+		if fresh && slices.ContainsFunc(c.GetStringSlice("amr"), func(m string) bool {
+			return slices.Contains(methods, m)
+		}) {
+			c.Next()
+			return
+		}
+
+		seconds := int(maxAge.Seconds())
+		c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_user_authentication", max_age=%d`, seconds))
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error":   "step_up_required",
+			"message": "re-authenticate to continue",
+			"max_age": seconds,
+			"methods": methods,
+		})
+	}
+}
@@ -1,19 +1,125 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
 
 type User struct {
-	ID           string    `json:"id" db:"id"`
-	Email        string    `json:"email" db:"email"`
-	PasswordHash string    `json:"-" db:"password_hash"`
-	Name         string    `json:"name" db:"name"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+	ID              string     `json:"id" db:"id"`
+	Email           string     `json:"email" db:"email"`
+	PasswordHash    string     `json:"-" db:"password_hash"`
+	Name            string     `json:"name" db:"name"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at" db:"email_verified_at"`
+	TOTPSecret      string     `json:"-" db:"totp_secret"`
+	TOTPEnabledAt   *time.Time `json:"totp_enabled_at" db:"totp_enabled_at"`
+	// Login throttling state, never exposed to clients
+	FailedLoginCount int        `json:"-" db:"failed_login_count"`
+	LockedUntil      *time.Time `json:"-" db:"locked_until"`
+	ClosedAt         *time.Time `json:"closed_at,omitempty" db:"closed_at"`
+	// Set by an admin; disabled users can't sign in until enabled again
+	DisabledAt *time.Time `json:"disabled_at,omitempty" db:"disabled_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// RefreshToken is one token of a session. ClientID and Scope are set for
+// sessions of third-party OAuth clients.
+type RefreshToken struct {
+	ID        string     `json:"id" db:"id"`
+	UserID    string     `json:"user_id" db:"user_id"`
+	FamilyID  string     `json:"family_id" db:"family_id"`
+	AuthTime  time.Time  `json:"auth_time" db:"auth_time"`
+	AMR       []string   `json:"amr" db:"amr"`
+	ClientID  *string    `json:"client_id" db:"client_id"`
+	Scope     string     `json:"scope" db:"scope"`
+	DPoPJKT   *string    `json:"dpop_jkt" db:"dpop_jkt"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	RotatedAt *time.Time `json:"rotated_at" db:"rotated_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+func (t *RefreshToken) Authentication() Authentication {
+	return Authentication{Time: t.AuthTime, Methods: t.AMR}
+}
+
+// Client types reported through the X-Client-Type header
+const (
+	ClientTypeWeb     = "web"
+	ClientTypeMobile  = "mobile"
+	ClientTypeUnknown = "unknown"
+)
+
+// ClientInfo describes the device a refresh token was issued to.
+type ClientInfo struct {
+	UserAgent  string
+	IPAddress  string
+	ClientType string
+}
+
+// Session is a refresh token family as listed to its user. ID is the
+// family ID, which access tokens carry in the sid claim. OAuthClient names
+// the third-party app holding the session, if any.
+type Session struct {
+	ID          string    `json:"id" db:"family_id"`
+	UserAgent   string    `json:"user_agent" db:"user_agent"`
+	IPAddress   string    `json:"ip_address" db:"ip_address"`
+	ClientType  string    `json:"client_type" db:"client_type"`
+	OAuthClient *string   `json:"oauth_client,omitempty" db:"oauth_client"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at" db:"last_used_at"`
+	ExpiresAt   time.Time `json:"expires_at" db:"expires_at"`
+	Current     bool      `json:"current"`
 }
 
+// Authentication method references (RFC 8176) recorded in the amr claim
+const (
+	AMRPassword    = "pwd"
+	AMROTP         = "otp"
+	AMRMultiFactor = "mfa"
+	// AMREmail is not registered in RFC 8176; it marks a login through a
+	// link sent by email
+	AMREmail = "email"
+)
+
+// Authentication describes when and how the user last proved their
+// identity. It becomes the auth_time, amr and acr claims and is kept with
+// the session so refreshed tokens carry the original values.
+type Authentication struct {
+	Time    time.Time
+	Methods []string
+}
+
+// IsLocked reports whether logins are refused because of repeated failures.
+func (u *User) IsLocked() bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(time.Now())
+}
+
+func NewAuthentication(methods ...string) Authentication {
+	return Authentication{Time: time.Now(), Methods: methods}
+}
+
+// ACR maps the methods to a NIST assurance level: aal2 once a second
+// factor was used, aal1 otherwise.
+func (a Authentication) ACR() string {
+	for _, m := range a.Methods {
+		if m == AMRMultiFactor {
+			return "aal2"
+		}
+	}
+	return "aal1"
+}
+
+// Purposes of the single-use tokens in auth.user_tokens
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+)
+
 type RegisterRequest struct {
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=8"`
+	Password string `json:"password" binding:"required"`
 	Name     string `json:"name" binding:"required,min=2"`
 }
 
@@ -22,8 +128,11 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// AuthResponse carries a token pair. TokenType is DPoP when the tokens are
+// bound to the key of the request's DPoP proof.
 type AuthResponse struct {
 	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
 	RefreshToken string `json:"refresh_token"`
 	User         *User  `json:"user"`
 }
@@ -31,3 +140,413 @@ type AuthResponse struct {
 type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token" binding:"required"`
 }
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type SigningKey struct {
+	KID         string     `json:"kid" db:"kid"`
+	Algorithm   string     `json:"algorithm" db:"algorithm"`
+	PrivateKey  string     `json:"-" db:"private_key"`
+	Status      string     `json:"status" db:"status"`
+	ActivatesAt time.Time  `json:"activates_at" db:"activates_at"`
+	RetiringAt  *time.Time `json:"retiring_at" db:"retiring_at"`
+	RetiredAt   *time.Time `json:"retired_at" db:"retired_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type UpdateProfileRequest struct {
+	Name string `json:"name" binding:"required,min=2,max=255"`
+}
+
+// EmailChange is a request to move an account to a new address.
+type EmailChange struct {
+	ID          string     `json:"id" db:"id"`
+	UserID      string     `json:"user_id" db:"user_id"`
+	OldEmail    string     `json:"old_email" db:"old_email"`
+	NewEmail    string     `json:"new_email" db:"new_email"`
+	ConfirmedAt *time.Time `json:"confirmed_at" db:"confirmed_at"`
+}
+
+type EmailChangeRequest struct {
+	NewEmail string `json:"new_email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+type EmailChangeTokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type CloseAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+	// Code is the TOTP or recovery code, required when TOTP is enabled
+	Code string `json:"code"`
+	// SweepToEmail receives the remaining balance; without it the
+	// balance must be zero
+	SweepToEmail string `json:"sweep_to_email" binding:"omitempty,email"`
+}
+
+type SecurityEvent struct {
+	ID        string                 `json:"id" db:"id"`
+	EventType string                 `json:"event_type" db:"event_type"`
+	Details   map[string]interface{} `json:"details" db:"details"`
+	CreatedAt time.Time              `json:"created_at" db:"created_at"`
+}
+
+// Outcomes of an audited request
+const (
+	AuditSuccess     = "success"
+	AuditFailure     = "failure"
+	AuditMFARequired = "mfa_required"
+)
+
+// EventUserRegistered tells payment service to open an account for a new
+// user.
+const EventUserRegistered = "user.registered"
+
+// OutboxEvent is an event waiting in auth.outbox_events to be delivered.
+type OutboxEvent struct {
+	ID        string          `json:"id" db:"id"`
+	Type      string          `json:"type" db:"event_type"`
+	Payload   json.RawMessage `json:"payload" db:"payload"`
+	Attempts  int             `json:"-" db:"attempts"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+	// Set on events the receiver rejected, which are no longer retried
+	LastError *string    `json:"-" db:"last_error"`
+	FailedAt  *time.Time `json:"-" db:"failed_at"`
+}
+
+// AuditEvent is one entry of auth.audit_events. UserID is the account the
+// request was about and ActorID whoever was signed in making it.
+type AuditEvent struct {
+	ID        int64                  `json:"id" db:"id"`
+	UserID    *string                `json:"user_id" db:"user_id"`
+	ActorID   *string                `json:"actor_id" db:"actor_id"`
+	EventType string                 `json:"event_type" db:"event_type"`
+	Outcome   string                 `json:"outcome" db:"outcome"`
+	IPAddress string                 `json:"ip_address" db:"ip_address"`
+	UserAgent string                 `json:"user_agent" db:"user_agent"`
+	Details   map[string]interface{} `json:"details" db:"details"`
+	CreatedAt time.Time              `json:"created_at" db:"created_at"`
+}
+
+// AuditFilter selects audit events, newest first. Before is the ID of the
+// last event of the previous page.
+type AuditFilter struct {
+	UserID    string     `form:"user_id" binding:"omitempty,uuid"`
+	Email     string     `form:"email"`
+	EventType string     `form:"event_type"`
+	Outcome   string     `form:"outcome"`
+	IPAddress string     `form:"ip_address"`
+	From      *time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To        *time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Before    int64      `form:"before" binding:"omitempty,min=1"`
+	Limit     int        `form:"limit" binding:"omitempty,min=1,max=200"`
+}
+
+// UserFilter selects users for the admin search. Query matches part of the
+// email or name, or the whole ID.
+type UserFilter struct {
+	Query  string `form:"q"`
+	Offset int    `form:"offset" binding:"omitempty,min=0"`
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=200"`
+}
+
+// AdminUser is a user as shown to staff, with the state users don't see.
+type AdminUser struct {
+	*User
+	Roles            []string   `json:"roles"`
+	FailedLoginCount int        `json:"failed_login_count"`
+	LockedUntil      *time.Time `json:"locked_until"`
+}
+
+type DisableUserRequest struct {
+	Reason string `json:"reason" binding:"max=500"`
+}
+
+// Built-in roles; custom roles can be added next to them
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+type Role struct {
+	Name        string    `json:"name" db:"name"`
+	Description string    `json:"description" db:"description"`
+	Builtin     bool      `json:"builtin" db:"builtin"`
+	Permissions []string  `json:"permissions" db:"permissions"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// PersonalAccessTokenPrefix marks personal access tokens, so services can
+// tell them apart from JWTs and secret scanners can find leaked ones.
+const PersonalAccessTokenPrefix = "dogpay_pat_"
+
+// Scopes a personal access token can be granted
+var PersonalAccessTokenScopes = []string{"balance:read", "history:read", "transfer:write"}
+
+type PersonalAccessToken struct {
+	ID          string     `json:"id" db:"id"`
+	Name        string     `json:"name" db:"name"`
+	TokenPrefix string     `json:"token_prefix" db:"token_prefix"`
+	Scopes      []string   `json:"scopes" db:"scopes"`
+	ExpiresAt   *time.Time `json:"expires_at" db:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at" db:"last_used_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+}
+
+type CreatePersonalAccessTokenRequest struct {
+	Name   string   `json:"name" binding:"required,max=100"`
+	Scopes []string `json:"scopes" binding:"required,min=1"`
+	// ExpiresInDays is optional; tokens without it never expire
+	ExpiresInDays int `json:"expires_in_days" binding:"omitempty,min=1,max=365"`
+}
+
+// CreatePersonalAccessTokenResponse is the only time the token is shown.
+type CreatePersonalAccessTokenResponse struct {
+	PersonalAccessToken
+	Token string `json:"token"`
+}
+
+// OAuth scopes a third-party client can be registered for. The OpenID
+// Connect scopes reach the userinfo endpoint, the others payment-service.
+var OAuthScopes = []string{
+	"openid", "profile", "email", "offline_access",
+	"balance:read", "history:read", "transfer:write",
+}
+
+// ScopePermissions names the permission each payment scope needs from the
+// user's roles. Tokens of third-party clients carry only these, so they
+// can't do more than both the user and the consent allow.
+var ScopePermissions = map[string]string{
+	"transfer:write": "payments:transfer",
+}
+
+// ServiceScopes are granted to service clients through the
+// client-credentials grant, mapped to the audience of the service that
+// accepts them.
+var ServiceScopes = map[string]string{
+	"payments:internal": "payment-service",
+	"tokens:introspect": "auth-service",
+}
+
+// OAuth grant types a client can be registered for
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+// OAuthClient is a third-party app or, with the client-credentials grant, a
+// service. Public clients have no secret and rely on PKCE alone.
+type OAuthClient struct {
+	ID           string    `json:"id" db:"id"`
+	SecretHash   *string   `json:"-" db:"secret_hash"`
+	Name         string    `json:"name" db:"name"`
+	RedirectURIs []string  `json:"redirect_uris" db:"redirect_uris"`
+	Scopes       []string  `json:"scopes" db:"scopes"`
+	GrantTypes   []string  `json:"grant_types" db:"grant_types"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+func (c *OAuthClient) Public() bool {
+	return c.SecretHash == nil
+}
+
+func (c *OAuthClient) Allows(grantType string) bool {
+	return slices.Contains(c.GrantTypes, grantType)
+}
+
+// CreateOAuthClientRequest registers an app, or a service when Service is
+// set. Services are always confidential and take no redirect URIs.
+type CreateOAuthClientRequest struct {
+	Name         string   `json:"name" binding:"required,max=255"`
+	RedirectURIs []string `json:"redirect_uris" binding:"omitempty,dive,url"`
+	Scopes       []string `json:"scopes" binding:"required,min=1"`
+	Public       bool     `json:"public"`
+	Service      bool     `json:"service"`
+}
+
+// CreateOAuthClientResponse is the only time the client secret is shown.
+type CreateOAuthClientResponse struct {
+	OAuthClient
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// OAuthAuthorizationRequest is an authorization request waiting for the
+// user to approve or deny it on the consent screen.
+type OAuthAuthorizationRequest struct {
+	ID            string    `json:"id" db:"id"`
+	ClientID      string    `json:"client_id" db:"client_id"`
+	RedirectURI   string    `json:"redirect_uri" db:"redirect_uri"`
+	Scope         string    `json:"scope" db:"scope"`
+	State         string    `json:"-" db:"state"`
+	Nonce         string    `json:"-" db:"nonce"`
+	CodeChallenge string    `json:"-" db:"code_challenge"`
+	ExpiresAt     time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// OAuthAuthorizationCode is a code issued after consent, exchanged once
+// for tokens. FamilyID is the session the exchange created.
+type OAuthAuthorizationCode struct {
+	ClientID      string     `db:"client_id"`
+	UserID        string     `db:"user_id"`
+	RedirectURI   string     `db:"redirect_uri"`
+	Scope         string     `db:"scope"`
+	Nonce         string     `db:"nonce"`
+	CodeChallenge string     `db:"code_challenge"`
+	AuthTime      time.Time  `db:"auth_time"`
+	AMR           []string   `db:"amr"`
+	FamilyID      *string    `db:"family_id"`
+	ExpiresAt     time.Time  `db:"expires_at"`
+	ConsumedAt    *time.Time `db:"consumed_at"`
+}
+
+// ConsentResponse tells the consent screen who is asking for what.
+type ConsentResponse struct {
+	RequestID  string   `json:"request_id"`
+	ClientID   string   `json:"client_id"`
+	ClientName string   `json:"client_name"`
+	Scopes     []string `json:"scopes"`
+}
+
+type ConsentRequest struct {
+	Approve bool `json:"approve"`
+}
+
+type ConsentDecisionResponse struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+// OAuthTokenResponse is the RFC 6749 token response, with the OpenID
+// Connect id_token when the openid scope was granted.
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// IntrospectionResponse is the RFC 7662 introspection response. Inactive
+// tokens only get Active.
+type IntrospectionResponse struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	TokenID   string   `json:"jti,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
+	// Set for DPoP-bound tokens, so the resource server can check the proof
+	Confirmation *TokenConfirmation `json:"cnf,omitempty"`
+}
+
+// TokenConfirmation names the DPoP key a token is bound to by its RFC 7638
+// thumbprint.
+type TokenConfirmation struct {
+	JKT string `json:"jkt"`
+}
+
+// UserInfo holds the OpenID Connect standard claims released by scope.
+type UserInfo struct {
+	Subject       string `json:"sub"`
+	Name          string `json:"name,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+}
+
+// MagicLink is an open passwordless login link.
+type MagicLink struct {
+	ID          string    `db:"id"`
+	UserID      string    `db:"user_id"`
+	BindingHash string    `db:"binding_hash"`
+	ExpiresAt   time.Time `db:"expires_at"`
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// MagicLinkResponse holds the binding secret the requesting client has to
+// present with the link. It is returned whether or not the email exists.
+type MagicLinkResponse struct {
+	Message       string `json:"message"`
+	BindingSecret string `json:"binding_secret"`
+	ExpiresIn     int    `json:"expires_in"`
+}
+
+type MagicLinkVerifyRequest struct {
+	Token         string `json:"token" binding:"required"`
+	BindingSecret string `json:"binding_secret" binding:"required"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// MFAChallengeResponse is returned by Login instead of an AuthResponse when
+// the account has two-factor authentication enabled.
+type MFAChallengeResponse struct {
+	MFARequired bool     `json:"mfa_required"`
+	MFAToken    string   `json:"mfa_token"`
+	Methods     []string `json:"methods"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type TOTPEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type TOTPDisableRequest struct {
+	Password     string `json:"password" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// StepUpRequest re-authenticates an existing session with the password or,
+// when TOTP is enabled, a TOTP code.
+type StepUpRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type StepUpResponse struct {
+	AccessToken string `json:"access_token"`
+}
@@ -0,0 +1,66 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// sample is a small corpus of well-known leaked passwords, used when no
+// full corpus is configured.
+//
+//go:embed breached
+var sample embed.FS
+
+// Corpus looks passwords up in an offline copy of a breached-password list
+// laid out like the Have I Been Pwned range API: one file per uppercase
+// 5-character SHA-1 prefix, holding "SUFFIX:COUNT" lines for the remaining
+// 35 characters.
+type Corpus struct {
+	fsys fs.FS
+}
+
+func NewCorpus(fsys fs.FS) *Corpus {
+	return &Corpus{fsys: fsys}
+}
+
+// SampleCorpus returns the corpus embedded in the binary.
+func SampleCorpus() *Corpus {
+	sub, err := fs.Sub(sample, "breached")
+	if err != nil {
+		panic(err)
+	}
+	return NewCorpus(sub)
+}
+
+// Contains reports whether password appears in the corpus.
+func (c *Corpus) Contains(password string) (bool, error) {
+	sum := fmt.Sprintf("%X", sha1.Sum([]byte(password)))
+	prefix, suffix := sum[:5], sum[5:]
+
+	f, err := c.fsys.Open(prefix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("open breached password range %s: %w", prefix, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		hash, _, _ := strings.Cut(line, ":")
+		if strings.EqualFold(hash, suffix) {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("read breached password range %s: %w", prefix, err)
+	}
+	return false, nil
+}
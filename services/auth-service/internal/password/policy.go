@@ -0,0 +1,133 @@
+package password
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policy decides which new passwords are acceptable. Following NIST SP
+// 800-63B it does not require character classes; it rejects passwords that
+// are short, predictable, derived from the account or already leaked.
+type Policy struct {
+	MinLength int
+	MaxLength int
+	// BannedPatterns are matched against the lowercased password.
+	BannedPatterns []*regexp.Regexp
+	// Breached is optional; lookups that fail are logged and skipped.
+	Breached *Corpus
+}
+
+// DefaultPolicy rejects the service name and the usual placeholder words.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		MinLength:      8,
+		MaxLength:      128,
+		BannedPatterns: BannedWords("dogpay", "password", "senha", "qwerty", "123456", "abcdef"),
+		Breached:       SampleCorpus(),
+	}
+}
+
+// BannedWords builds case-insensitive patterns matching any of words.
+func BannedWords(words ...string) []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, 0, len(words))
+	for _, w := range words {
+		w = strings.ToLower(strings.TrimSpace(w))
+		if w == "" {
+			continue
+		}
+		patterns = append(patterns, regexp.MustCompile(regexp.QuoteMeta(w)))
+	}
+	return patterns
+}
+
+// Check returns the reasons password is rejected, or nil when it is
+// acceptable. context holds account data such as the email and name that
+// the password must not contain.
+func (p *Policy) Check(password string, context ...string) []string {
+	var problems []string
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		problems = append(problems, fmt.Sprintf("must be at most %d characters", p.MaxLength))
+	}
+
+	lower := strings.ToLower(password)
+	for _, pattern := range p.BannedPatterns {
+		if pattern.MatchString(lower) {
+			problems = append(problems, "must not contain common words or patterns")
+			break
+		}
+	}
+
+	if repetitive(lower) {
+		problems = append(problems, "must not be made of repeated or sequential characters")
+	}
+
+	if containsContext(lower, context) {
+		problems = append(problems, "must not contain your email or name")
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			log.Printf("breached password lookup failed: %v", err)
+		}
+		if breached {
+			problems = append(problems, "appears in a known data breach, choose a different password")
+		}
+	}
+
+	return problems
+}
+
+// repetitive reports whether s only consists of one character repeated or
+// a run of consecutive characters such as "12345678" or "hgfedcba".
+func repetitive(s string) bool {
+	runes := []rune(s)
+	if len(runes) < 2 {
+		return false
+	}
+
+	step := runes[1] - runes[0]
+	if step < -1 || step > 1 {
+		return false
+	}
+	for i := 2; i < len(runes); i++ {
+		if runes[i]-runes[i-1] != step {
+			return false
+		}
+	}
+	return true
+}
+
+// containsContext reports whether lower contains any word of at least
+// three characters taken from the context values. Emails contribute both
+// their local part and its pieces split on punctuation.
+func containsContext(lower string, context []string) bool {
+	for _, value := range context {
+		value = strings.ToLower(value)
+		if local, _, ok := strings.Cut(value, "@"); ok {
+			value = local
+			if len([]rune(local)) >= 3 && strings.Contains(lower, local) {
+				return true
+			}
+		}
+
+		words := strings.FieldsFunc(value, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, w := range words {
+			if len([]rune(w)) >= 3 && strings.Contains(lower, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
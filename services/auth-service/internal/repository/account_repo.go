@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// CloseUser anonymizes a user and removes everything that lets the
+// account sign in. The row itself stays: retained payment records refer
+// to it. Security and audit events keep their type and time but lose their
+// details, IP addresses and user agents, including audit events that only
+// name the account by its email.
+func (r *UserRepository) CloseUser(ctx context.Context, userID string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var email string
+	err = tx.QueryRow(ctx, `
+		SELECT email FROM auth.users WHERE id = $1 AND closed_at IS NULL FOR UPDATE
+	`, userID).Scan(&email)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("find user: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE auth.users SET
+			email = 'closed+' || id || '@dogpay.invalid',
+			name = 'Conta encerrada',
+			password_hash = '',
+			email_verified_at = NULL,
+			totp_secret = NULL,
+			totp_enabled_at = NULL,
+			totp_last_step = NULL,
+			failed_login_count = 0,
+			last_failed_login_at = NULL,
+			locked_until = NULL,
+			closed_at = NOW(),
+			updated_at = NOW()
+		WHERE id = $1 AND closed_at IS NULL
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("anonymize user: %w", err)
+	}
+
+	for _, table := range []string{
+		"auth.refresh_tokens",
+		"auth.user_tokens",
+		"auth.recovery_codes",
+		"auth.mfa_challenges",
+		"auth.email_change_requests",
+		"auth.personal_access_tokens",
+		"auth.magic_links",
+	} {
+		if _, err := tx.Exec(ctx, `DELETE FROM `+table+` WHERE user_id = $1`, userID); err != nil {
+			return fmt.Errorf("delete from %s: %w", table, err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE auth.security_events SET details = '{}' WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("clear security event details: %w", err)
+	}
+
+	// The audit log only allows this redaction, see 022_audit_redaction.sql
+	_, err = tx.Exec(ctx, `
+		UPDATE auth.audit_events SET details = '{}', ip_address = '', user_agent = ''
+		WHERE user_id = $1 OR (user_id IS NULL AND $2 <> '' AND details->>'email' = $2)
+	`, userID, email)
+	if err != nil {
+		return fmt.Errorf("redact audit events: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (r *UserRepository) ListSecurityEvents(ctx context.Context, userID string) ([]models.SecurityEvent, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, event_type, details, created_at
+		FROM auth.security_events
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list security events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []models.SecurityEvent{}
+	for rows.Next() {
+		var e models.SecurityEvent
+		if err := rows.Scan(&e.ID, &e.EventType, &e.Details, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan security event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+const adminUserColumns = userColumns + `,
+	COALESCE(ARRAY(SELECT role FROM auth.user_roles ur WHERE ur.user_id = auth.users.id ORDER BY role), '{}')`
+
+func scanAdminUser(row pgx.Row) (*models.AdminUser, error) {
+	user := &models.User{}
+	admin := &models.AdminUser{User: user}
+	err := row.Scan(
+		&user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.EmailVerifiedAt,
+		&user.TOTPSecret, &user.TOTPEnabledAt, &user.FailedLoginCount, &user.LockedUntil,
+		&user.ClosedAt, &user.DisabledAt, &user.CreatedAt, &user.UpdatedAt, &admin.Roles,
+	)
+	if err != nil {
+		return nil, err
+	}
+	admin.FailedLoginCount = user.FailedLoginCount
+	admin.LockedUntil = user.LockedUntil
+	return admin, nil
+}
+
+// SearchUsers returns the users matching filter, newest first.
+func (r *UserRepository) SearchUsers(ctx context.Context, filter models.UserFilter) ([]models.AdminUser, error) {
+	limit := filter.Limit
+	if limit == 0 {
+		limit = 50
+	}
+
+	rows, err := r.db.Query(ctx, `
+		SELECT `+adminUserColumns+`
+		FROM auth.users
+		WHERE $1 = ''
+		   OR id::text = lower($1)
+		   OR strpos(lower(email), lower($1)) > 0
+		   OR strpos(lower(name), lower($1)) > 0
+		ORDER BY created_at DESC, id
+		OFFSET $2 LIMIT $3
+	`, filter.Query, filter.Offset, limit)
+	if err != nil {
+		return nil, fmt.Errorf("search users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []models.AdminUser{}
+	for rows.Next() {
+		user, err := scanAdminUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan user: %w", err)
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+func (r *UserRepository) FindAdminUser(ctx context.Context, id string) (*models.AdminUser, error) {
+	user, err := scanAdminUser(r.db.QueryRow(ctx, `
+		SELECT `+adminUserColumns+`
+		FROM auth.users
+		WHERE id = $1
+	`, id))
+	if err != nil {
+		return nil, fmt.Errorf("find user by id: %w", err)
+	}
+	return user, nil
+}
+
+// DisableUser stops an open account from signing in: its sessions and
+// pending logins are removed and its access tokens revoked. It reports
+// false when the account is closed or already disabled.
+func (r *UserRepository) DisableUser(ctx context.Context, userID string) (bool, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE auth.users SET
+			disabled_at = NOW(),
+			tokens_valid_after = date_trunc('second', NOW()),
+			updated_at = NOW()
+		WHERE id = $1 AND closed_at IS NULL AND disabled_at IS NULL
+	`, userID)
+	if err != nil {
+		return false, fmt.Errorf("disable user: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	for _, table := range []string{
+		"auth.refresh_tokens",
+		"auth.mfa_challenges",
+		"auth.magic_links",
+	} {
+		if _, err := tx.Exec(ctx, `DELETE FROM `+table+` WHERE user_id = $1`, userID); err != nil {
+			return false, fmt.Errorf("delete from %s: %w", table, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("commit transaction: %w", err)
+	}
+	return true, nil
+}
+
+// EnableUser lets a disabled account sign in again. It reports false when
+// the account is not disabled.
+func (r *UserRepository) EnableUser(ctx context.Context, userID string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE auth.users SET disabled_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND disabled_at IS NOT NULL
+	`, userID)
+	if err != nil {
+		return false, fmt.Errorf("enable user: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/dogpay/auth-service/internal/models"
+)
+
+func (r *UserRepository) RecordAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO auth.audit_events (user_id, actor_id, event_type, outcome, ip_address, user_agent, details)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, event.UserID, event.ActorID, event.EventType, event.Outcome, event.IPAddress, event.UserAgent, event.Details)
+	if err != nil {
+		return fmt.Errorf("record audit event: %w", err)
+	}
+	return nil
+}
+
+// ListAuditEvents returns the events matching filter, newest first. Email
+// matches events about the account that has it now as well as attempts
+// against it recorded before any account existed.
+func (r *UserRepository) ListAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if filter.UserID != "" {
+		where("user_id = ?", filter.UserID)
+	}
+	if filter.Email != "" {
+		where("(user_id = (SELECT id FROM auth.users WHERE email = ?) OR details->>'email' = ?)", filter.Email)
+	}
+	if filter.EventType != "" {
+		where("event_type = ?", filter.EventType)
+	}
+	if filter.Outcome != "" {
+		where("outcome = ?", filter.Outcome)
+	}
+	if filter.IPAddress != "" {
+		where("ip_address = ?", filter.IPAddress)
+	}
+	if filter.From != nil {
+		where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		where("created_at < ?", *filter.To)
+	}
+	if filter.Before > 0 {
+		where("id < ?", filter.Before)
+	}
+
+	limit := filter.Limit
+	if limit == 0 {
+		limit = 50
+	}
+
+	query := `
+		SELECT id, user_id::text, actor_id::text, event_type, outcome, ip_address, user_agent, details, created_at
+		FROM auth.audit_events`
+	if len(conditions) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf("\n\t\tORDER BY id DESC\n\t\tLIMIT $%d", len(args))
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		var e models.AuditEvent
+		if err := rows.Scan(&e.ID, &e.UserID, &e.ActorID, &e.EventType, &e.Outcome, &e.IPAddress, &e.UserAgent, &e.Details, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan audit event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
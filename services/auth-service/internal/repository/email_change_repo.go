@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrEmailTaken is returned when an email change would collide with an
+// address another account registered in the meantime.
+var ErrEmailTaken = errors.New("email already registered")
+
+// ErrEmailChanged is returned when a confirmed change can't be reverted
+// because the account's address has changed again since.
+var ErrEmailChanged = errors.New("email changed since")
+
+// CreateEmailChange stores a pending email change, superseding any change
+// the user started before and has not confirmed.
+func (r *UserRepository) CreateEmailChange(ctx context.Context, userID, oldEmail, newEmail, confirmHash, cancelHash string, expiresAt, cancelExpiresAt time.Time) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE auth.email_change_requests SET cancelled_at = NOW()
+		WHERE user_id = $1 AND confirmed_at IS NULL AND cancelled_at IS NULL
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("cancel previous email changes: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO auth.email_change_requests
+			(user_id, old_email, new_email, confirm_token_hash, cancel_token_hash, expires_at, cancel_expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, userID, oldEmail, newEmail, confirmHash, cancelHash, expiresAt, cancelExpiresAt)
+	if err != nil {
+		return fmt.Errorf("create email change: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ConfirmEmailChange swaps in the new address of a pending change. The
+// address counts as verified, since the confirmation link was sent to it.
+func (r *UserRepository) ConfirmEmailChange(ctx context.Context, confirmHash string) (*models.User, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var change models.EmailChange
+	err = tx.QueryRow(ctx, `
+		SELECT id, user_id, old_email, new_email
+		FROM auth.email_change_requests
+		WHERE confirm_token_hash = $1 AND confirmed_at IS NULL AND cancelled_at IS NULL AND expires_at > NOW()
+		FOR UPDATE
+	`, confirmHash).Scan(&change.ID, &change.UserID, &change.OldEmail, &change.NewEmail)
+	if err != nil {
+		return nil, fmt.Errorf("find email change: %w", err)
+	}
+
+	// Only applies while the account still has the address the change
+	// started from
+	user, err := scanUser(tx.QueryRow(ctx, `
+		UPDATE auth.users SET email = $3, email_verified_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND email = $2
+		RETURNING `+userColumns, change.UserID, change.OldEmail, change.NewEmail))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrEmailTaken
+		}
+		return nil, fmt.Errorf("update email: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE auth.email_change_requests SET confirmed_at = NOW() WHERE id = $1
+	`, change.ID)
+	if err != nil {
+		return nil, fmt.Errorf("confirm email change: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+	return user, nil
+}
+
+// CancelEmailChange cancels a change from the link sent to the old
+// address. A change that was already confirmed is reverted and every
+// session and personal access token of the user is revoked, since whoever
+// confirmed it may have taken over the account.
+func (r *UserRepository) CancelEmailChange(ctx context.Context, cancelHash string) (*models.EmailChange, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	change := &models.EmailChange{}
+	err = tx.QueryRow(ctx, `
+		SELECT id, user_id, old_email, new_email, confirmed_at
+		FROM auth.email_change_requests
+		WHERE cancel_token_hash = $1 AND cancelled_at IS NULL AND cancel_expires_at > NOW()
+		FOR UPDATE
+	`, cancelHash).Scan(&change.ID, &change.UserID, &change.OldEmail, &change.NewEmail, &change.ConfirmedAt)
+	if err != nil {
+		return nil, fmt.Errorf("find email change: %w", err)
+	}
+
+	if change.ConfirmedAt != nil {
+		tag, err := tx.Exec(ctx, `
+			UPDATE auth.users SET email = $3, tokens_valid_after = date_trunc('second', NOW()), updated_at = NOW()
+			WHERE id = $1 AND email = $2
+		`, change.UserID, change.NewEmail, change.OldEmail)
+		if err != nil {
+			if isUniqueViolation(err) {
+				return nil, ErrEmailTaken
+			}
+			return nil, fmt.Errorf("revert email: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return nil, ErrEmailChanged
+		}
+
+		_, err = tx.Exec(ctx, `
+			DELETE FROM auth.refresh_tokens WHERE user_id = $1
+		`, change.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("delete user refresh tokens: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, `DELETE FROM auth.personal_access_tokens WHERE user_id = $1`, change.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("delete personal access tokens: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE auth.email_change_requests SET cancelled_at = NOW() WHERE id = $1
+	`, change.ID)
+	if err != nil {
+		return nil, fmt.Errorf("cancel email change: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+	return change, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dogpay/auth-service/internal/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type KeyRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewKeyRepository(db *pgxpool.Pool) *KeyRepository {
+	return &KeyRepository{db: db}
+}
+
+func (r *KeyRepository) Create(ctx context.Context, kid, privateKeyPEM string, activatesAt time.Time) (*models.SigningKey, error) {
+	key := &models.SigningKey{}
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO auth.signing_keys (kid, private_key, activates_at)
+		VALUES ($1, $2, $3)
+		RETURNING kid, algorithm, private_key, status, activates_at, retiring_at, retired_at, created_at
+	`, kid, privateKeyPEM, activatesAt).Scan(
+		&key.KID, &key.Algorithm, &key.PrivateKey, &key.Status,
+		&key.ActivatesAt, &key.RetiringAt, &key.RetiredAt, &key.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create signing key: %w", err)
+	}
+	return key, nil
+}
+
+// List returns every key, newest first. Retired keys are only included when
+// includeRetired is set.
+func (r *KeyRepository) List(ctx context.Context, includeRetired bool) ([]models.SigningKey, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT kid, algorithm, private_key, status, activates_at, retiring_at, retired_at, created_at
+		FROM auth.signing_keys
+		WHERE $1 OR status <> 'retired'
+		ORDER BY activates_at DESC
+	`, includeRetired)
+	if err != nil {
+		return nil, fmt.Errorf("list signing keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []models.SigningKey
+	for rows.Next() {
+		var key models.SigningKey
+		if err := rows.Scan(
+			&key.KID, &key.Algorithm, &key.PrivateKey, &key.Status,
+			&key.ActivatesAt, &key.RetiringAt, &key.RetiredAt, &key.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Advance moves the keyring forward: active keys superseded by a newer key
+// that has already activated start retiring, and keys that have been
+// retiring for longer than retention are retired.
+func (r *KeyRepository) Advance(ctx context.Context, retention time.Duration) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE auth.signing_keys SET status = 'retiring', retiring_at = NOW()
+		WHERE status = 'active' AND activates_at <= NOW() AND kid <> (
+			SELECT kid FROM auth.signing_keys
+			WHERE status = 'active' AND activates_at <= NOW()
+			ORDER BY activates_at DESC
+			LIMIT 1
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("start retiring signing keys: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE auth.signing_keys SET status = 'retired', retired_at = NOW()
+		WHERE status = 'retiring' AND retiring_at < NOW() - make_interval(secs => $1)
+	`, retention.Seconds())
+	if err != nil {
+		return fmt.Errorf("retire signing keys: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dogpay/auth-service/internal/models"
+)
+
+// CreateMagicLink stores a new link for userID and drops the user's older
+// open links, so only the latest email works.
+func (r *UserRepository) CreateMagicLink(ctx context.Context, userID, tokenHash, bindingHash string, expiresAt time.Time) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM auth.magic_links WHERE user_id = $1 AND consumed_at IS NULL`, userID); err != nil {
+		return fmt.Errorf("delete magic links: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO auth.magic_links (user_id, token_hash, binding_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, userID, tokenHash, bindingHash, expiresAt)
+	if err != nil {
+		return fmt.Errorf("create magic link: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *UserRepository) FindMagicLink(ctx context.Context, tokenHash string) (*models.MagicLink, error) {
+	link := &models.MagicLink{}
+	err := r.db.QueryRow(ctx, `
+		SELECT id, user_id, binding_hash, expires_at
+		FROM auth.magic_links
+		WHERE token_hash = $1 AND consumed_at IS NULL AND expires_at > NOW()
+	`, tokenHash).Scan(&link.ID, &link.UserID, &link.BindingHash, &link.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("find magic link: %w", err)
+	}
+	return link, nil
+}
+
+// ConsumeMagicLink marks a link as used. It reports false when another
+// request used it first.
+func (r *UserRepository) ConsumeMagicLink(ctx context.Context, id string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE auth.magic_links SET consumed_at = NOW() WHERE id = $1 AND consumed_at IS NULL
+	`, id)
+	if err != nil {
+		return false, fmt.Errorf("consume magic link: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,214 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrMFAChallengeConsumed is returned when a concurrent request completed
+// the same challenge first.
+var ErrMFAChallengeConsumed = errors.New("mfa challenge already consumed")
+
+// execer runs a statement on the pool or inside a transaction.
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// SetPendingTOTPSecret stores a secret for an enrollment that still has to
+// be confirmed. It fails when TOTP is already enabled.
+func (r *UserRepository) SetPendingTOTPSecret(ctx context.Context, userID, secret string) error {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE auth.users SET totp_secret = $2, totp_last_step = NULL, updated_at = NOW()
+		WHERE id = $1 AND totp_enabled_at IS NULL
+	`, userID, secret)
+	if err != nil {
+		return fmt.Errorf("set totp secret: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("set totp secret: totp already enabled")
+	}
+	return nil
+}
+
+// EnableTOTP turns on the pending secret and replaces the recovery codes.
+func (r *UserRepository) EnableTOTP(ctx context.Context, userID string, step int64, codeHashes []string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE auth.users SET totp_enabled_at = NOW(), totp_last_step = $2, updated_at = NOW()
+		WHERE id = $1 AND totp_secret IS NOT NULL AND totp_enabled_at IS NULL
+	`, userID, step)
+	if err != nil {
+		return fmt.Errorf("enable totp: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("enable totp: no pending enrollment")
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *UserRepository) DisableTOTP(ctx context.Context, userID string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE auth.users
+		SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL, updated_at = NOW()
+		WHERE id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("disable totp: %w", err)
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, nil); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// UseTOTPStep records step as the last accepted code. It reports false when
+// a code from this or a later step was already used, so codes can't be
+// replayed within their validity window.
+func (r *UserRepository) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	return useTOTPStep(ctx, r.db, userID, step)
+}
+
+func useTOTPStep(ctx context.Context, db execer, userID string, step int64) (bool, error) {
+	tag, err := db.Exec(ctx, `
+		UPDATE auth.users SET totp_last_step = $2
+		WHERE id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)
+	`, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("use totp step: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *UserRepository) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	return useRecoveryCode(ctx, r.db, userID, codeHash)
+}
+
+func useRecoveryCode(ctx context.Context, db execer, userID, codeHash string) (bool, error) {
+	tag, err := db.Exec(ctx, `
+		UPDATE auth.recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("use recovery code: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *UserRepository) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID string, codeHashes []string) error {
+	_, err := tx.Exec(ctx, `DELETE FROM auth.recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+
+	for _, hash := range codeHashes {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO auth.recovery_codes (user_id, code_hash) VALUES ($1, $2)
+		`, userID, hash)
+		if err != nil {
+			return fmt.Errorf("store recovery code: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *UserRepository) CreateMFAChallenge(ctx context.Context, userID, tokenHash, firstFactor string, expiresAt time.Time) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO auth.mfa_challenges (user_id, token_hash, first_factor, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, userID, tokenHash, firstFactor, expiresAt)
+	if err != nil {
+		return fmt.Errorf("create mfa challenge: %w", err)
+	}
+	return nil
+}
+
+// AttemptMFAChallenge counts a verification attempt against an open
+// challenge and returns its id, user and the first factor that started it.
+// Challenges stop working after maxAttempts, so codes cannot be brute
+// forced within one challenge.
+func (r *UserRepository) AttemptMFAChallenge(ctx context.Context, tokenHash string, maxAttempts int) (id, userID, firstFactor string, err error) {
+	err = r.db.QueryRow(ctx, `
+		UPDATE auth.mfa_challenges SET attempts = attempts + 1
+		WHERE token_hash = $1 AND consumed_at IS NULL AND expires_at > NOW() AND attempts < $2
+		RETURNING id, user_id, first_factor
+	`, tokenHash, maxAttempts).Scan(&id, &userID, &firstFactor)
+	if err != nil {
+		return "", "", "", fmt.Errorf("attempt mfa challenge: %w", err)
+	}
+	return id, userID, firstFactor, nil
+}
+
+// CompleteMFAChallenge consumes a challenge together with the second factor
+// answering it: the TOTP step, or the recovery code when codeHash is set.
+// It reports false when the factor was already used, and returns
+// ErrMFAChallengeConsumed when a concurrent request completed the challenge
+// first. Either way neither is used up, so a lost race costs no recovery
+// code.
+func (r *UserRepository) CompleteMFAChallenge(ctx context.Context, id, userID string, step int64, codeHash string) (bool, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Locks the challenge, so a concurrent request waits here and then
+	// finds it consumed
+	tag, err := tx.Exec(ctx, `
+		UPDATE auth.mfa_challenges SET consumed_at = NOW() WHERE id = $1 AND consumed_at IS NULL
+	`, id)
+	if err != nil {
+		return false, fmt.Errorf("consume mfa challenge: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return false, ErrMFAChallengeConsumed
+	}
+
+	var used bool
+	if codeHash != "" {
+		used, err = useRecoveryCode(ctx, tx, userID, codeHash)
+	} else {
+		used, err = useTOTPStep(ctx, tx, userID, step)
+	}
+	if err != nil || !used {
+		return false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("commit transaction: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dogpay/auth-service/internal/models"
+)
+
+const oauthClientColumns = `id, secret_hash, name, redirect_uris, scopes, grant_types, created_at`
+
+func (r *UserRepository) CreateOAuthClient(ctx context.Context, id string, secretHash *string, name string, redirectURIs, scopes, grantTypes []string) (*models.OAuthClient, error) {
+	client := &models.OAuthClient{}
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO auth.oauth_clients (id, secret_hash, name, redirect_uris, scopes, grant_types)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+oauthClientColumns, id, secretHash, name, redirectURIs, scopes, grantTypes,
+	).Scan(&client.ID, &client.SecretHash, &client.Name, &client.RedirectURIs, &client.Scopes, &client.GrantTypes, &client.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("create oauth client: %w", err)
+	}
+	return client, nil
+}
+
+func (r *UserRepository) FindOAuthClient(ctx context.Context, id string) (*models.OAuthClient, error) {
+	client := &models.OAuthClient{}
+	err := r.db.QueryRow(ctx, `
+		SELECT `+oauthClientColumns+`
+		FROM auth.oauth_clients
+		WHERE id = $1
+	`, id).Scan(&client.ID, &client.SecretHash, &client.Name, &client.RedirectURIs, &client.Scopes, &client.GrantTypes, &client.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("find oauth client: %w", err)
+	}
+	return client, nil
+}
+
+func (r *UserRepository) CreateAuthorizationRequest(ctx context.Context, req *models.OAuthAuthorizationRequest) (string, error) {
+	var id string
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO auth.oauth_authorization_requests
+			(client_id, redirect_uri, scope, state, nonce, code_challenge, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, req.ClientID, req.RedirectURI, req.Scope, req.State, req.Nonce, req.CodeChallenge, req.ExpiresAt).Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("create authorization request: %w", err)
+	}
+	return id, nil
+}
+
+func (r *UserRepository) FindAuthorizationRequest(ctx context.Context, id string) (*models.OAuthAuthorizationRequest, error) {
+	req := &models.OAuthAuthorizationRequest{}
+	err := r.db.QueryRow(ctx, `
+		SELECT id, client_id, redirect_uri, scope, state, nonce, code_challenge, expires_at
+		FROM auth.oauth_authorization_requests
+		WHERE id::text = $1 AND expires_at > NOW()
+	`, id).Scan(&req.ID, &req.ClientID, &req.RedirectURI, &req.Scope, &req.State, &req.Nonce, &req.CodeChallenge, &req.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("find authorization request: %w", err)
+	}
+	return req, nil
+}
+
+// DeleteAuthorizationRequest removes a request once the user decided on it.
+// It reports false when the request was already decided, so a decision is
+// only acted on once.
+func (r *UserRepository) DeleteAuthorizationRequest(ctx context.Context, id string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM auth.oauth_authorization_requests WHERE id::text = $1`, id)
+	if err != nil {
+		return false, fmt.Errorf("delete authorization request: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *UserRepository) CreateAuthorizationCode(ctx context.Context, codeHash string, code *models.OAuthAuthorizationCode) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO auth.oauth_authorization_codes
+			(code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, auth_time, amr, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`, codeHash, code.ClientID, code.UserID, code.RedirectURI, code.Scope, code.Nonce,
+		code.CodeChallenge, code.AuthTime, code.AMR, code.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("create authorization code: %w", err)
+	}
+	return nil
+}
+
+// ConsumeAuthorizationCode marks a code as used and returns it. ConsumedAt
+// is the time of an earlier use, so callers can detect a replayed code.
+func (r *UserRepository) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*models.OAuthAuthorizationCode, error) {
+	code := &models.OAuthAuthorizationCode{}
+	err := r.db.QueryRow(ctx, `
+		WITH previous AS (
+			SELECT consumed_at FROM auth.oauth_authorization_codes WHERE code_hash = $1 FOR UPDATE
+		)
+		UPDATE auth.oauth_authorization_codes c
+		SET consumed_at = COALESCE(c.consumed_at, NOW())
+		FROM previous
+		WHERE c.code_hash = $1
+		RETURNING c.client_id, c.user_id, c.redirect_uri, c.scope, c.nonce, c.code_challenge,
+		          c.auth_time, c.amr, c.family_id::text, c.expires_at, previous.consumed_at
+	`, codeHash).Scan(
+		&code.ClientID, &code.UserID, &code.RedirectURI, &code.Scope, &code.Nonce, &code.CodeChallenge,
+		&code.AuthTime, &code.AMR, &code.FamilyID, &code.ExpiresAt, &code.ConsumedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("consume authorization code: %w", err)
+	}
+	return code, nil
+}
+
+// SetAuthorizationCodeFamily records the session a code was exchanged for.
+func (r *UserRepository) SetAuthorizationCodeFamily(ctx context.Context, codeHash, familyID string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE auth.oauth_authorization_codes SET family_id = $2 WHERE code_hash = $1
+	`, codeHash, familyID)
+	if err != nil {
+		return fmt.Errorf("set authorization code family: %w", err)
+	}
+	return nil
+}
+
+// PruneOAuthRequests drops expired authorization requests and codes.
+func (r *UserRepository) PruneOAuthRequests(ctx context.Context) error {
+	if _, err := r.db.Exec(ctx, `DELETE FROM auth.oauth_authorization_requests WHERE expires_at < NOW()`); err != nil {
+		return fmt.Errorf("prune authorization requests: %w", err)
+	}
+	// Used codes are kept a little longer so replays are still recognised
+	if _, err := r.db.Exec(ctx, `DELETE FROM auth.oauth_authorization_codes WHERE expires_at < NOW() - INTERVAL '1 day'`); err != nil {
+		return fmt.Errorf("prune authorization codes: %w", err)
+	}
+	return nil
+}
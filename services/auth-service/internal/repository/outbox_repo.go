@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dogpay/auth-service/internal/models"
+)
+
+// ClaimOutboxEvents takes up to limit events that are due for delivery and
+// holds them for lease, so concurrent relays don't send the same event.
+// An event whose relay dies is picked up again once the lease runs out.
+func (r *UserRepository) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEvent, error) {
+	rows, err := r.db.Query(ctx, `
+		UPDATE auth.outbox_events SET
+			attempts = attempts + 1,
+			next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM auth.outbox_events
+			WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= NOW()
+			ORDER BY created_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, event_type, payload, attempts, created_at
+	`, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("claim outbox events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []models.OutboxEvent{}
+	for rows.Next() {
+		var e models.OutboxEvent
+		if err := rows.Scan(&e.ID, &e.Type, &e.Payload, &e.Attempts, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan outbox event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (r *UserRepository) MarkOutboxEventDelivered(ctx context.Context, id string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE auth.outbox_events SET delivered_at = NOW(), last_error = NULL WHERE id = $1
+	`, id)
+	if err != nil {
+		return fmt.Errorf("mark outbox event delivered: %w", err)
+	}
+	return nil
+}
+
+// RetryOutboxEvent schedules another delivery attempt after a failure.
+func (r *UserRepository) RetryOutboxEvent(ctx context.Context, id string, at time.Time, lastError string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE auth.outbox_events SET next_attempt_at = $2, last_error = $3 WHERE id = $1
+	`, id, at, lastError)
+	if err != nil {
+		return fmt.Errorf("retry outbox event: %w", err)
+	}
+	return nil
+}
+
+// FailOutboxEvent stops retrying an event the receiver rejected for good.
+func (r *UserRepository) FailOutboxEvent(ctx context.Context, id string, lastError string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE auth.outbox_events SET failed_at = NOW(), last_error = $2 WHERE id = $1
+	`, id, lastError)
+	if err != nil {
+		return fmt.Errorf("fail outbox event: %w", err)
+	}
+	return nil
+}
+
+func (r *UserRepository) ListFailedOutboxEvents(ctx context.Context) ([]models.OutboxEvent, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, event_type, payload, attempts, created_at, last_error, failed_at
+		FROM auth.outbox_events
+		WHERE failed_at IS NOT NULL
+		ORDER BY failed_at
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list failed outbox events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []models.OutboxEvent{}
+	for rows.Next() {
+		var e models.OutboxEvent
+		if err := rows.Scan(&e.ID, &e.Type, &e.Payload, &e.Attempts, &e.CreatedAt, &e.LastError, &e.FailedAt); err != nil {
+			return nil, fmt.Errorf("scan outbox event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// RequeueOutboxEvent makes a failed event due for delivery again. It
+// reports false when there is no such failed event.
+func (r *UserRepository) RequeueOutboxEvent(ctx context.Context, id string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE auth.outbox_events SET failed_at = NULL, attempts = 0, next_attempt_at = NOW()
+		WHERE id::text = $1 AND failed_at IS NOT NULL
+	`, id)
+	if err != nil {
+		return false, fmt.Errorf("requeue outbox event: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// PruneOutboxEvents drops events delivered before the given time.
+func (r *UserRepository) PruneOutboxEvents(ctx context.Context, before time.Time) error {
+	_, err := r.db.Exec(ctx, `
+		DELETE FROM auth.outbox_events WHERE delivered_at < $1
+	`, before)
+	if err != nil {
+		return fmt.Errorf("prune outbox events: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dogpay/auth-service/internal/models"
+)
+
+const patColumns = `id, name, token_prefix, scopes, expires_at, last_used_at, created_at`
+
+func (r *UserRepository) CreatePersonalAccessToken(ctx context.Context, userID, name, tokenHash, tokenPrefix string, scopes []string, expiresAt *time.Time) (*models.PersonalAccessToken, error) {
+	pat := &models.PersonalAccessToken{}
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO auth.personal_access_tokens (user_id, name, token_hash, token_prefix, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+patColumns, userID, name, tokenHash, tokenPrefix, scopes, expiresAt,
+	).Scan(&pat.ID, &pat.Name, &pat.TokenPrefix, &pat.Scopes, &pat.ExpiresAt, &pat.LastUsedAt, &pat.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("create personal access token: %w", err)
+	}
+	return pat, nil
+}
+
+// ListPersonalAccessTokens returns the tokens of a user, expired ones
+// included so they can be cleaned up.
+func (r *UserRepository) ListPersonalAccessTokens(ctx context.Context, userID string) ([]models.PersonalAccessToken, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT `+patColumns+`
+		FROM auth.personal_access_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list personal access tokens: %w", err)
+	}
+	defer rows.Close()
+
+	pats := []models.PersonalAccessToken{}
+	for rows.Next() {
+		var pat models.PersonalAccessToken
+		if err := rows.Scan(&pat.ID, &pat.Name, &pat.TokenPrefix, &pat.Scopes, &pat.ExpiresAt, &pat.LastUsedAt, &pat.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan personal access token: %w", err)
+		}
+		pats = append(pats, pat)
+	}
+	return pats, rows.Err()
+}
+
+// DeletePersonalAccessToken revokes a token of a user. It reports false
+// when the user has no such token.
+func (r *UserRepository) DeletePersonalAccessToken(ctx context.Context, userID, id string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		DELETE FROM auth.personal_access_tokens WHERE user_id = $1 AND id::text = $2
+	`, userID, id)
+	if err != nil {
+		return false, fmt.Errorf("delete personal access token: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// DeleteUserPersonalAccessTokens revokes every token of a user, when they
+// or an admin sign them out everywhere.
+func (r *UserRepository) DeleteUserPersonalAccessTokens(ctx context.Context, userID string) error {
+	_, err := r.db.Exec(ctx, `
+		DELETE FROM auth.personal_access_tokens WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("delete user personal access tokens: %w", err)
+	}
+	return nil
+}
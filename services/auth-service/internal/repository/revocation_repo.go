@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// AccessTokenActive reports whether an unexpired access token is still
+// valid: it was not revoked by jti, its user is open and not disabled and
+// has not revoked their tokens since issuedAt, and its session, if any,
+// still exists.
+// userID and sessionID are nil for tokens without a user or session.
+func (r *UserRepository) AccessTokenActive(ctx context.Context, jti string, userID, sessionID *string, issuedAt time.Time) (bool, error) {
+	var active bool
+	err := r.db.QueryRow(ctx, `
+		SELECT NOT EXISTS (SELECT 1 FROM auth.revoked_tokens WHERE jti = $1)
+		   AND ($2::uuid IS NULL OR EXISTS (
+		        SELECT 1 FROM auth.users u
+		        WHERE u.id = $2 AND u.closed_at IS NULL AND u.disabled_at IS NULL
+		          AND (u.tokens_valid_after IS NULL OR u.tokens_valid_after <= $3)))
+		   AND ($4::uuid IS NULL OR EXISTS (
+		        SELECT 1 FROM auth.refresh_tokens t WHERE t.family_id = $4 AND t.expires_at > NOW()))
+	`, jti, userID, issuedAt, sessionID).Scan(&active)
+	if err != nil {
+		return false, fmt.Errorf("check access token: %w", err)
+	}
+	return active, nil
+}
+
+// RevokeAccessToken revokes a single access token until it expires.
+func (r *UserRepository) RevokeAccessToken(ctx context.Context, jti string, userID *string, expiresAt time.Time) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO auth.revoked_tokens (jti, user_id, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (jti) DO NOTHING
+	`, jti, userID, expiresAt)
+	if err != nil {
+		return fmt.Errorf("revoke access token: %w", err)
+	}
+	return nil
+}
+
+// RevokeUserAccessTokens revokes every access token issued to a user so far.
+// Token iat has whole seconds, so the cutoff is truncated to keep tokens
+// issued right after it valid.
+func (r *UserRepository) RevokeUserAccessTokens(ctx context.Context, userID string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE auth.users SET tokens_valid_after = date_trunc('second', NOW()) WHERE id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("revoke user access tokens: %w", err)
+	}
+	return nil
+}
+
+// PruneRevokedTokens drops revocations of tokens that have expired anyway.
+func (r *UserRepository) PruneRevokedTokens(ctx context.Context) error {
+	if _, err := r.db.Exec(ctx, `DELETE FROM auth.revoked_tokens WHERE expires_at < NOW()`); err != nil {
+		return fmt.Errorf("prune revoked tokens: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dogpay/auth-service/internal/models"
+)
+
+// UserAccess returns the roles of a user and the union of their
+// permissions, both sorted.
+func (r *UserRepository) UserAccess(ctx context.Context, userID string) ([]string, []string, error) {
+	var roles, permissions []string
+	err := r.db.QueryRow(ctx, `
+		SELECT
+			COALESCE(ARRAY(SELECT role FROM auth.user_roles WHERE user_id = $1 ORDER BY role), '{}'),
+			COALESCE(ARRAY(
+				SELECT DISTINCT rp.permission
+				FROM auth.user_roles ur
+				JOIN auth.role_permissions rp ON rp.role = ur.role
+				WHERE ur.user_id = $1
+				ORDER BY rp.permission
+			), '{}')
+	`, userID).Scan(&roles, &permissions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get user access: %w", err)
+	}
+	return roles, permissions, nil
+}
+
+func (r *UserRepository) ListRoles(ctx context.Context) ([]models.Role, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT ro.name, ro.description, ro.builtin,
+		       COALESCE(ARRAY(SELECT permission FROM auth.role_permissions WHERE role = ro.name ORDER BY permission), '{}'),
+		       ro.created_at
+		FROM auth.roles ro
+		ORDER BY ro.builtin DESC, ro.name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list roles: %w", err)
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		var role models.Role
+		if err := rows.Scan(&role.Name, &role.Description, &role.Builtin, &role.Permissions, &role.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan role: %w", err)
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// CreateRole adds a custom role, or replaces the permissions of an
+// existing one.
+func (r *UserRepository) CreateRole(ctx context.Context, name, description string, permissions []string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO auth.roles (name, description) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET description = EXCLUDED.description
+	`, name, description)
+	if err != nil {
+		return fmt.Errorf("create role: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM auth.role_permissions WHERE role = $1`, name); err != nil {
+		return fmt.Errorf("clear role permissions: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO auth.role_permissions (role, permission)
+		SELECT $1, unnest($2::text[])
+		ON CONFLICT DO NOTHING
+	`, name, permissions)
+	if err != nil {
+		return fmt.Errorf("set role permissions: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (r *UserRepository) GrantRole(ctx context.Context, userID, role string) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO auth.user_roles (user_id, role) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, userID, role)
+	if err != nil {
+		return fmt.Errorf("grant role: %w", err)
+	}
+	return nil
+}
+
+func (r *UserRepository) RevokeRole(ctx context.Context, userID, role string) error {
+	_, err := r.db.Exec(ctx, `
+		DELETE FROM auth.user_roles WHERE user_id = $1 AND role = $2
+	`, userID, role)
+	if err != nil {
+		return fmt.Errorf("revoke role: %w", err)
+	}
+	return nil
+}
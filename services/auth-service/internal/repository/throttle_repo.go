@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RecordLoginFailure counts a failed login and returns the number of
+// consecutive failures. The streak starts over when the previous failure is
+// older than resetAfter.
+func (r *UserRepository) RecordLoginFailure(ctx context.Context, userID string, resetAfter time.Duration) (int, error) {
+	var count int
+	err := r.db.QueryRow(ctx, `
+		UPDATE auth.users SET
+			failed_login_count = CASE
+				WHEN last_failed_login_at < NOW() - make_interval(secs => $2) THEN 1
+				ELSE failed_login_count + 1
+			END,
+			last_failed_login_at = NOW()
+		WHERE id = $1
+		RETURNING failed_login_count
+	`, userID, resetAfter.Seconds()).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("record login failure: %w", err)
+	}
+	return count, nil
+}
+
+func (r *UserRepository) LockUser(ctx context.Context, userID string, until time.Time) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE auth.users SET locked_until = $2 WHERE id = $1
+	`, userID, until)
+	if err != nil {
+		return fmt.Errorf("lock user: %w", err)
+	}
+	return nil
+}
+
+// UnlockUser clears the lockout and the failure streak. It is used after a
+// successful login and by administrators.
+func (r *UserRepository) UnlockUser(ctx context.Context, userID string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE auth.users SET failed_login_count = 0, last_failed_login_at = NULL, locked_until = NULL
+		WHERE id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("unlock user: %w", err)
+	}
+	return nil
+}
+
+// HitRateLimit increments the counter of bucket for the fixed window that
+// contains now and returns the updated count.
+func (r *UserRepository) HitRateLimit(ctx context.Context, bucket string, window time.Duration) (int, error) {
+	var count int
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO auth.rate_limits (bucket, window_start, count)
+		VALUES ($1, $2, 1)
+		ON CONFLICT (bucket, window_start) DO UPDATE SET count = auth.rate_limits.count + 1
+		RETURNING count
+	`, bucket, time.Now().Truncate(window)).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("hit rate limit: %w", err)
+	}
+	return count, nil
+}
+
+func (r *UserRepository) PruneRateLimits(ctx context.Context, before time.Time) error {
+	_, err := r.db.Exec(ctx, `
+		DELETE FROM auth.rate_limits WHERE window_start < $1
+	`, before)
+	if err != nil {
+		return fmt.Errorf("prune rate limits: %w", err)
+	}
+	return nil
+}
@@ -3,8 +3,10 @@ package repository
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/dogpay/auth-service/internal/models"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -16,15 +18,38 @@ func NewUserRepository(db *pgxpool.Pool) *UserRepository {
 	return &UserRepository{db: db}
 }
 
-func (r *UserRepository) Create(ctx context.Context, email, passwordHash, name string) (*models.User, error) {
+const userColumns = `id, email, password_hash, name, email_verified_at,
+	COALESCE(totp_secret, ''), totp_enabled_at, failed_login_count, locked_until, closed_at, disabled_at, created_at, updated_at`
+
+func scanUser(row pgx.Row) (*models.User, error) {
 	user := &models.User{}
-	err := r.db.QueryRow(ctx, `
-		INSERT INTO auth.users (email, password_hash, name)
-		VALUES ($1, $2, $3)
-		RETURNING id, email, password_hash, name, created_at, updated_at
-	`, email, passwordHash, name).Scan(
-		&user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.CreatedAt, &user.UpdatedAt,
+	err := row.Scan(
+		&user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.EmailVerifiedAt,
+		&user.TOTPSecret, &user.TOTPEnabledAt, &user.FailedLoginCount, &user.LockedUntil,
+		&user.ClosedAt, &user.DisabledAt, &user.CreatedAt, &user.UpdatedAt,
 	)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Create inserts a user with the default role and, in the same statement,
+// the outbox event that opens their payment account.
+func (r *UserRepository) Create(ctx context.Context, email, passwordHash, name string) (*models.User, error) {
+	user, err := scanUser(r.db.QueryRow(ctx, `
+		WITH created AS (
+			INSERT INTO auth.users (email, password_hash, name)
+			VALUES ($1, $2, $3)
+			RETURNING *
+		), granted AS (
+			INSERT INTO auth.user_roles (user_id, role)
+			SELECT id, $4 FROM created
+		), announced AS (
+			INSERT INTO auth.outbox_events (event_type, payload)
+			SELECT $5, jsonb_build_object('user_id', id) FROM created
+		)
+		SELECT `+userColumns+` FROM created`, email, passwordHash, name, models.RoleUser, models.EventUserRegistered))
 	if err != nil {
 		return nil, fmt.Errorf("create user: %w", err)
 	}
@@ -32,14 +57,11 @@ func (r *UserRepository) Create(ctx context.Context, email, passwordHash, name s
 }
 
 func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
-	user := &models.User{}
-	err := r.db.QueryRow(ctx, `
-		SELECT id, email, password_hash, name, created_at, updated_at
+	user, err := scanUser(r.db.QueryRow(ctx, `
+		SELECT `+userColumns+`
 		FROM auth.users
 		WHERE email = $1
-	`, email).Scan(
-		&user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.CreatedAt, &user.UpdatedAt,
-	)
+	`, email))
 	if err != nil {
 		return nil, fmt.Errorf("find user by email: %w", err)
 	}
@@ -47,41 +69,115 @@ func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*models
 }
 
 func (r *UserRepository) FindByID(ctx context.Context, id string) (*models.User, error) {
-	user := &models.User{}
-	err := r.db.QueryRow(ctx, `
-		SELECT id, email, password_hash, name, created_at, updated_at
+	user, err := scanUser(r.db.QueryRow(ctx, `
+		SELECT `+userColumns+`
 		FROM auth.users
 		WHERE id = $1
-	`, id).Scan(
-		&user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.CreatedAt, &user.UpdatedAt,
-	)
+	`, id))
 	if err != nil {
 		return nil, fmt.Errorf("find user by id: %w", err)
 	}
 	return user, nil
 }
 
-func (r *UserRepository) StoreRefreshToken(ctx context.Context, userID, tokenHash string, expiresAt interface{}) error {
-	_, err := r.db.Exec(ctx, `
-		INSERT INTO auth.refresh_tokens (user_id, token_hash, expires_at)
-		VALUES ($1, $2, $3)
-	`, userID, tokenHash, expiresAt)
+// StoreRefreshToken inserts a refresh token into token.FamilyID, or into a
+// new family when that is empty, and returns the family ID.
+func (r *UserRepository) StoreRefreshToken(ctx context.Context, token *models.RefreshToken, tokenHash string, client models.ClientInfo) (string, error) {
+	var familyID string
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO auth.refresh_tokens
+			(user_id, family_id, token_hash, expires_at, auth_time, amr, client_id, scope, dpop_jkt, user_agent, ip_address, client_type)
+		VALUES ($1, COALESCE(NULLIF($2, '')::uuid, gen_random_uuid()), $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING family_id
+	`, token.UserID, token.FamilyID, tokenHash, token.ExpiresAt, token.AuthTime, token.AMR, token.ClientID, token.Scope,
+		token.DPoPJKT, client.UserAgent, client.IPAddress, client.ClientType,
+	).Scan(&familyID)
 	if err != nil {
-		return fmt.Errorf("store refresh token: %w", err)
+		return "", fmt.Errorf("store refresh token: %w", err)
 	}
-	return nil
+	return familyID, nil
 }
 
-func (r *UserRepository) FindRefreshToken(ctx context.Context, tokenHash string) (string, error) {
-	var userID string
+// FindRefreshToken returns unexpired tokens including already rotated ones,
+// so callers can tell a replay apart from an unknown token.
+func (r *UserRepository) FindRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	token := &models.RefreshToken{}
 	err := r.db.QueryRow(ctx, `
-		SELECT user_id FROM auth.refresh_tokens
+		SELECT id, user_id, family_id, auth_time, amr, client_id, scope, dpop_jkt, expires_at, rotated_at, created_at
+		FROM auth.refresh_tokens
 		WHERE token_hash = $1 AND expires_at > NOW()
-	`, tokenHash).Scan(&userID)
+	`, tokenHash).Scan(
+		&token.ID, &token.UserID, &token.FamilyID, &token.AuthTime, &token.AMR, &token.ClientID, &token.Scope,
+		&token.DPoPJKT, &token.ExpiresAt, &token.RotatedAt, &token.CreatedAt,
+	)
 	if err != nil {
-		return "", fmt.Errorf("find refresh token: %w", err)
+		return nil, fmt.Errorf("find refresh token: %w", err)
 	}
-	return userID, nil
+	return token, nil
+}
+
+// RotateRefreshToken marks a token as used. It reports false when the token
+// was already rotated, which happens when two requests race with one token.
+func (r *UserRepository) RotateRefreshToken(ctx context.Context, tokenHash string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE auth.refresh_tokens SET rotated_at = NOW()
+		WHERE token_hash = $1 AND rotated_at IS NULL
+	`, tokenHash)
+	if err != nil {
+		return false, fmt.Errorf("rotate refresh token: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *UserRepository) DeleteRefreshTokenFamily(ctx context.Context, familyID string) error {
+	_, err := r.db.Exec(ctx, `
+		DELETE FROM auth.refresh_tokens WHERE family_id = $1
+	`, familyID)
+	if err != nil {
+		return fmt.Errorf("delete refresh token family: %w", err)
+	}
+	return nil
+}
+
+// ListSessions returns the live sessions of a user, most recently used
+// first. A session is described by its newest token, which holds the
+// device that last refreshed it; it started with the oldest one.
+func (r *UserRepository) ListSessions(ctx context.Context, userID string) ([]models.Session, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT t.family_id, t.user_agent, t.ip_address, t.client_type, oc.name,
+		       (SELECT MIN(created_at) FROM auth.refresh_tokens f WHERE f.family_id = t.family_id),
+		       t.last_used_at, t.expires_at
+		FROM auth.refresh_tokens t
+		LEFT JOIN auth.oauth_clients oc ON oc.id = t.client_id
+		WHERE t.user_id = $1 AND t.rotated_at IS NULL AND t.expires_at > NOW()
+		ORDER BY t.last_used_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var s models.Session
+		if err := rows.Scan(&s.ID, &s.UserAgent, &s.IPAddress, &s.ClientType, &s.OAuthClient, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("scan session: %w", err)
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteSession revokes one session of a user. It reports false when the
+// user has no such session.
+func (r *UserRepository) DeleteSession(ctx context.Context, userID, sessionID string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		DELETE FROM auth.refresh_tokens WHERE user_id = $1 AND family_id::text = $2
+	`, userID, sessionID)
+	if err != nil {
+		return false, fmt.Errorf("delete session: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
 }
 
 func (r *UserRepository) DeleteRefreshToken(ctx context.Context, tokenHash string) error {
@@ -90,3 +186,228 @@ func (r *UserRepository) DeleteRefreshToken(ctx context.Context, tokenHash strin
 	`, tokenHash)
 	return err
 }
+
+func (r *UserRepository) DeleteUserRefreshTokens(ctx context.Context, userID string) error {
+	_, err := r.db.Exec(ctx, `
+		DELETE FROM auth.refresh_tokens WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("delete user refresh tokens: %w", err)
+	}
+	return nil
+}
+
+// PruneRefreshTokens drops expired tokens, rotated or not. The first token
+// of a live session stays, since ListSessions dates the session by it.
+func (r *UserRepository) PruneRefreshTokens(ctx context.Context) error {
+	_, err := r.db.Exec(ctx, `
+		DELETE FROM auth.refresh_tokens t
+		WHERE t.expires_at < NOW()
+		  AND (NOT EXISTS (SELECT 1 FROM auth.refresh_tokens l WHERE l.family_id = t.family_id AND l.expires_at > NOW())
+		       OR EXISTS (SELECT 1 FROM auth.refresh_tokens o WHERE o.family_id = t.family_id AND o.created_at < t.created_at))
+	`)
+	if err != nil {
+		return fmt.Errorf("prune refresh tokens: %w", err)
+	}
+	return nil
+}
+
+func (r *UserRepository) RecordSecurityEvent(ctx context.Context, userID, eventType string, details map[string]interface{}) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO auth.security_events (user_id, event_type, details)
+		VALUES ($1, $2, $3)
+	`, userID, eventType, details)
+	if err != nil {
+		return fmt.Errorf("record security event: %w", err)
+	}
+	return nil
+}
+
+// CreateUserToken stores a single-use token for purpose, replacing any
+// outstanding token of the same purpose so only the latest email works.
+func (r *UserRepository) CreateUserToken(ctx context.Context, userID, purpose, tokenHash string, expiresAt time.Time) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		DELETE FROM auth.user_tokens WHERE user_id = $1 AND purpose = $2 AND consumed_at IS NULL
+	`, userID, purpose)
+	if err != nil {
+		return fmt.Errorf("delete user tokens: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO auth.user_tokens (user_id, purpose, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, userID, purpose, tokenHash, expiresAt)
+	if err != nil {
+		return fmt.Errorf("create user token: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *UserRepository) UpdateName(ctx context.Context, userID, name string) (*models.User, error) {
+	user, err := scanUser(r.db.QueryRow(ctx, `
+		UPDATE auth.users SET name = $2, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+userColumns, userID, name))
+	if err != nil {
+		return nil, fmt.Errorf("update name: %w", err)
+	}
+	return user, nil
+}
+
+// ChangePassword stores a new password hash and revokes every session of
+// the user except keepSessionID, the one that made the change, and every
+// personal access token.
+func (r *UserRepository) ChangePassword(ctx context.Context, userID, passwordHash, keepSessionID string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE auth.users SET password_hash = $1, updated_at = NOW() WHERE id = $2
+	`, passwordHash, userID)
+	if err != nil {
+		return fmt.Errorf("update password: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		DELETE FROM auth.refresh_tokens WHERE user_id = $1 AND family_id::text <> $2
+	`, userID, keepSessionID)
+	if err != nil {
+		return fmt.Errorf("delete other refresh tokens: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM auth.personal_access_tokens WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("delete personal access tokens: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// UpdatePasswordHash swaps in a rehash of the same password. It only
+// applies while the stored hash is still oldHash, so it can't overwrite a
+// password change that happened in between.
+func (r *UserRepository) UpdatePasswordHash(ctx context.Context, userID, oldHash, newHash string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE auth.users SET password_hash = $3 WHERE id = $1 AND password_hash = $2
+	`, userID, oldHash, newHash)
+	if err != nil {
+		return fmt.Errorf("update password hash: %w", err)
+	}
+	return nil
+}
+
+// ResetPassword consumes a password reset token, sets the new password hash
+// and revokes every refresh, access and personal access token of the user in
+// a single transaction.
+func (r *UserRepository) ResetPassword(ctx context.Context, tokenHash, passwordHash string) (string, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	userID, err := consumeUserToken(ctx, tx, models.TokenPurposePasswordReset, tokenHash)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE auth.users SET
+			password_hash = $1,
+			tokens_valid_after = date_trunc('second', NOW()),
+			updated_at = NOW()
+		WHERE id = $2
+	`, passwordHash, userID)
+	if err != nil {
+		return "", fmt.Errorf("update password: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		DELETE FROM auth.refresh_tokens WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		return "", fmt.Errorf("delete user refresh tokens: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM auth.personal_access_tokens WHERE user_id = $1`, userID)
+	if err != nil {
+		return "", fmt.Errorf("delete personal access tokens: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("commit transaction: %w", err)
+	}
+	return userID, nil
+}
+
+// VerifyEmail consumes an email verification token and marks the address
+// of its user as verified.
+func (r *UserRepository) VerifyEmail(ctx context.Context, tokenHash string) (*models.User, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	userID, err := consumeUserToken(ctx, tx, models.TokenPurposeEmailVerification, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := scanUser(tx.QueryRow(ctx, `
+		UPDATE auth.users SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+userColumns, userID))
+	if err != nil {
+		return nil, fmt.Errorf("verify email: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+	return user, nil
+}
+
+// FindUserByToken returns the user of a valid token without consuming it.
+func (r *UserRepository) FindUserByToken(ctx context.Context, purpose, tokenHash string) (*models.User, error) {
+	user, err := scanUser(r.db.QueryRow(ctx, `
+		SELECT `+userColumns+`
+		FROM auth.users
+		WHERE id = (
+			SELECT user_id FROM auth.user_tokens
+			WHERE token_hash = $1 AND purpose = $2 AND consumed_at IS NULL AND expires_at > NOW()
+		)
+	`, tokenHash, purpose))
+	if err != nil {
+		return nil, fmt.Errorf("find user by %s token: %w", purpose, err)
+	}
+	return user, nil
+}
+
+// consumeUserToken marks a valid token as used and returns its user. Tokens
+// that are unknown, expired or already used yield an error.
+func consumeUserToken(ctx context.Context, tx pgx.Tx, purpose, tokenHash string) (string, error) {
+	var userID string
+	err := tx.QueryRow(ctx, `
+		UPDATE auth.user_tokens SET consumed_at = NOW()
+		WHERE token_hash = $1 AND purpose = $2 AND consumed_at IS NULL AND expires_at > NOW()
+		RETURNING user_id
+	`, tokenHash, purpose).Scan(&userID)
+	if err != nil {
+		return "", fmt.Errorf("consume %s token: %w", purpose, err)
+	}
+	return userID, nil
+}
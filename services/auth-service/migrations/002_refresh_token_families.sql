@@ -0,0 +1,17 @@
+-- Refresh token families: every token issued by rotation keeps the family of
+-- the token it replaced, so replaying a rotated token can revoke the session.
+ALTER TABLE auth.refresh_tokens
+    ADD COLUMN IF NOT EXISTS family_id  UUID NOT NULL DEFAULT gen_random_uuid(),
+    ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON auth.refresh_tokens(family_id);
+
+CREATE TABLE IF NOT EXISTS auth.security_events (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id     UUID REFERENCES auth.users(id) ON DELETE CASCADE,
+    event_type  VARCHAR(64) NOT NULL,
+    details     JSONB NOT NULL DEFAULT '{}',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_security_events_user_id ON auth.security_events(user_id);
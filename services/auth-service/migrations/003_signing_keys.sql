@@ -0,0 +1,16 @@
+-- Signing keyring. Active keys sign tokens once activates_at has passed;
+-- retiring keys are still published so in-flight tokens keep verifying;
+-- retired keys are neither published nor accepted.
+CREATE TABLE IF NOT EXISTS auth.signing_keys (
+    kid           VARCHAR(64) PRIMARY KEY,
+    algorithm     VARCHAR(16) NOT NULL DEFAULT 'EdDSA',
+    private_key   TEXT NOT NULL,
+    status        VARCHAR(16) NOT NULL DEFAULT 'active'
+                  CHECK (status IN ('active', 'retiring', 'retired')),
+    activates_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    retiring_at   TIMESTAMPTZ,
+    retired_at    TIMESTAMPTZ,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_signing_keys_status ON auth.signing_keys(status);
@@ -0,0 +1,13 @@
+-- Single-use tokens sent to users by email (password reset, ...).
+-- Only the SHA-256 hash is stored, like refresh tokens.
+CREATE TABLE IF NOT EXISTS auth.user_tokens (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id      UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    purpose      VARCHAR(32) NOT NULL,
+    token_hash   VARCHAR(255) NOT NULL UNIQUE,
+    expires_at   TIMESTAMPTZ NOT NULL,
+    consumed_at  TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user_purpose ON auth.user_tokens(user_id, purpose);
@@ -0,0 +1,5 @@
+-- Email verification. Accounts created before verification existed are
+-- treated as verified so they keep being able to transfer.
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+
+UPDATE auth.users SET email_verified_at = created_at WHERE email_verified_at IS NULL;
@@ -0,0 +1,27 @@
+-- TOTP two-factor authentication. totp_secret is set on enrollment and only
+-- enforced once totp_enabled_at is set by confirming a code.
+ALTER TABLE auth.users
+    ADD COLUMN IF NOT EXISTS totp_secret     VARCHAR(64),
+    ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS totp_last_step  BIGINT;
+
+CREATE TABLE IF NOT EXISTS auth.recovery_codes (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id     UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    code_hash   VARCHAR(255) NOT NULL UNIQUE,
+    used_at     TIMESTAMPTZ,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON auth.recovery_codes(user_id);
+
+-- Pending second-factor logins, created after the password was accepted
+CREATE TABLE IF NOT EXISTS auth.mfa_challenges (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id      UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    token_hash   VARCHAR(255) NOT NULL UNIQUE,
+    attempts     INT NOT NULL DEFAULT 0,
+    expires_at   TIMESTAMPTZ NOT NULL,
+    consumed_at  TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
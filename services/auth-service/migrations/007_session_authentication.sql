@@ -0,0 +1,5 @@
+-- How each session was authenticated, carried across refresh token
+-- rotation so step-up checks see the original login time and methods.
+ALTER TABLE auth.refresh_tokens
+    ADD COLUMN IF NOT EXISTS auth_time TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    ADD COLUMN IF NOT EXISTS amr       TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,15 @@
+-- Brute-force protection for /auth/login: per-account failure tracking with
+-- progressive lockout, and fixed-window counters for per-IP rate limits.
+ALTER TABLE auth.users
+    ADD COLUMN IF NOT EXISTS failed_login_count   INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS last_failed_login_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS locked_until         TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS auth.rate_limits (
+    bucket        VARCHAR(255) NOT NULL,
+    window_start  TIMESTAMPTZ NOT NULL,
+    count         INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (bucket, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limits_window_start ON auth.rate_limits(window_start);
@@ -0,0 +1,7 @@
+-- Device details of each session. Every token in a refresh token family
+-- records the client that obtained it; the family is the session.
+ALTER TABLE auth.refresh_tokens
+    ADD COLUMN IF NOT EXISTS user_agent   TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS ip_address   VARCHAR(45) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS client_type  VARCHAR(16) NOT NULL DEFAULT 'unknown',
+    ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
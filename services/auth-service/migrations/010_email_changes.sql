@@ -0,0 +1,18 @@
+-- Pending email changes. The new address is only written to auth.users
+-- once the link sent to it is confirmed; the old address gets a cancel link
+-- that also reverts a change that was already confirmed.
+CREATE TABLE IF NOT EXISTS auth.email_change_requests (
+    id                 UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id            UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    old_email          VARCHAR(255) NOT NULL,
+    new_email          VARCHAR(255) NOT NULL,
+    confirm_token_hash VARCHAR(255) NOT NULL UNIQUE,
+    cancel_token_hash  VARCHAR(255) NOT NULL UNIQUE,
+    expires_at         TIMESTAMPTZ NOT NULL,
+    cancel_expires_at  TIMESTAMPTZ NOT NULL,
+    confirmed_at       TIMESTAMPTZ,
+    cancelled_at       TIMESTAMPTZ,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_change_requests_user_id ON auth.email_change_requests(user_id);
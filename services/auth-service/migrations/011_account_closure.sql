@@ -0,0 +1,4 @@
+-- Closed accounts keep their row, anonymized, so payments.transactions
+-- that must be retained still point at a user.
+ALTER TABLE auth.users
+    ADD COLUMN IF NOT EXISTS closed_at TIMESTAMPTZ;
@@ -0,0 +1,43 @@
+-- Role-based access control. Roles bundle permissions; users get roles.
+-- Both are embedded in access tokens. Built-in roles can't be deleted,
+-- custom roles are created with `auth-service roles create`.
+CREATE TABLE IF NOT EXISTS auth.roles (
+    name        VARCHAR(64) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    builtin     BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS auth.role_permissions (
+    role        VARCHAR(64) NOT NULL REFERENCES auth.roles(name) ON DELETE CASCADE,
+    permission  VARCHAR(64) NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+
+CREATE TABLE IF NOT EXISTS auth.user_roles (
+    user_id     UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    role        VARCHAR(64) NOT NULL REFERENCES auth.roles(name) ON DELETE CASCADE,
+    granted_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, role)
+);
+
+INSERT INTO auth.roles (name, description, builtin) VALUES
+    ('user',    'Regular customer', TRUE),
+    ('support', 'Support staff, read-only access to customer data', TRUE),
+    ('admin',   'Administrator', TRUE)
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO auth.role_permissions (role, permission) VALUES
+    ('user',    'payments:transfer'),
+    ('support', 'users:read'),
+    ('support', 'accounts:read'),
+    ('admin',   'users:read'),
+    ('admin',   'users:write'),
+    ('admin',   'accounts:read'),
+    ('admin',   'accounts:write'),
+    ('admin',   'roles:write')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO auth.user_roles (user_id, role)
+SELECT id, 'user' FROM auth.users
+ON CONFLICT DO NOTHING;
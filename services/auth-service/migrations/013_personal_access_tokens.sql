@@ -0,0 +1,16 @@
+-- Long-lived tokens for scripts. Only the SHA-256 hash is stored; the
+-- prefix is kept so users can tell their tokens apart. Payment service
+-- looks tokens up here directly.
+CREATE TABLE IF NOT EXISTS auth.personal_access_tokens (
+    id            UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id       UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    name          VARCHAR(100) NOT NULL,
+    token_hash    VARCHAR(255) NOT NULL UNIQUE,
+    token_prefix  VARCHAR(32) NOT NULL,
+    scopes        TEXT[] NOT NULL,
+    expires_at    TIMESTAMPTZ,
+    last_used_at  TIMESTAMPTZ,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_personal_access_tokens_user_id ON auth.personal_access_tokens(user_id);
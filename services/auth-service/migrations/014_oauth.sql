@@ -0,0 +1,50 @@
+-- OAuth 2.1 / OpenID Connect provider for third-party apps.
+-- Public clients (mobile or browser apps) have no secret and rely on PKCE.
+CREATE TABLE IF NOT EXISTS auth.oauth_clients (
+    id             VARCHAR(64) PRIMARY KEY,
+    secret_hash    VARCHAR(255),
+    name           VARCHAR(255) NOT NULL,
+    redirect_uris  TEXT[] NOT NULL,
+    scopes         TEXT[] NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Authorization requests waiting for the user on the consent screen
+CREATE TABLE IF NOT EXISTS auth.oauth_authorization_requests (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    client_id       VARCHAR(64) NOT NULL REFERENCES auth.oauth_clients(id) ON DELETE CASCADE,
+    redirect_uri    TEXT NOT NULL,
+    scope           TEXT NOT NULL,
+    state           TEXT NOT NULL DEFAULT '',
+    nonce           TEXT NOT NULL DEFAULT '',
+    code_challenge  VARCHAR(128) NOT NULL,
+    expires_at      TIMESTAMPTZ NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS auth.oauth_authorization_codes (
+    code_hash       VARCHAR(255) PRIMARY KEY,
+    client_id       VARCHAR(64) NOT NULL REFERENCES auth.oauth_clients(id) ON DELETE CASCADE,
+    user_id         UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    redirect_uri    TEXT NOT NULL,
+    scope           TEXT NOT NULL,
+    nonce           TEXT NOT NULL DEFAULT '',
+    code_challenge  VARCHAR(128) NOT NULL,
+    auth_time       TIMESTAMPTZ NOT NULL,
+    amr             TEXT[] NOT NULL DEFAULT '{}',
+    -- Session created by the exchange, revoked if the code is replayed
+    family_id       UUID,
+    expires_at      TIMESTAMPTZ NOT NULL,
+    consumed_at     TIMESTAMPTZ,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Sessions of third-party apps are refresh token families like any other,
+-- tied to their client and granted scope
+ALTER TABLE auth.refresh_tokens
+    ADD COLUMN IF NOT EXISTS client_id VARCHAR(64) REFERENCES auth.oauth_clients(id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS scope     TEXT NOT NULL DEFAULT '';
+
+INSERT INTO auth.role_permissions (role, permission) VALUES
+    ('admin', 'clients:write')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,10 @@
+-- Service clients use the client-credentials grant to call other services'
+-- internal APIs; third-party apps keep the authorization code flow.
+ALTER TABLE auth.oauth_clients
+    ADD COLUMN IF NOT EXISTS grant_types TEXT[] NOT NULL DEFAULT '{authorization_code,refresh_token}';
+
+-- auth-service issues its own tokens for payment-service, so its client has
+-- no usable secret and cannot authenticate at the token endpoint
+INSERT INTO auth.oauth_clients (id, secret_hash, name, redirect_uris, scopes, grant_types) VALUES
+    ('auth-service', '!', 'Auth Service', '{}', '{payments:internal}', '{client_credentials}')
+ON CONFLICT (id) DO NOTHING;
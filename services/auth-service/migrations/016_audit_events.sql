@@ -0,0 +1,36 @@
+-- Append-only log of every authentication request, for incident
+-- investigation. user_id is the account the request was about, actor_id
+-- whoever was signed in when making it; both are empty for requests that
+-- could not be tied to an account, e.g. a login for an unknown email.
+CREATE TABLE IF NOT EXISTS auth.audit_events (
+    id          BIGSERIAL PRIMARY KEY,
+    user_id     UUID,
+    actor_id    UUID,
+    event_type  VARCHAR(64) NOT NULL,
+    outcome     VARCHAR(32) NOT NULL,
+    ip_address  VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent  VARCHAR(512) NOT NULL DEFAULT '',
+    details     JSONB NOT NULL DEFAULT '{}',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_user ON auth.audit_events(user_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_type ON auth.audit_events(event_type, id DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_ip ON auth.audit_events(ip_address, id DESC);
+
+-- Events are never changed or removed, not even when an account is closed
+CREATE OR REPLACE FUNCTION auth.audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'auth.audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON auth.audit_events;
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE OR TRUNCATE ON auth.audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION auth.audit_events_append_only();
+
+INSERT INTO auth.role_permissions (role, permission) VALUES
+    ('support', 'audit:read'),
+    ('admin', 'audit:read')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,18 @@
+-- Passwordless login links. binding_hash is the hash of a secret handed only
+-- to the client that asked for the link, so the link alone cannot sign in
+-- on another device.
+CREATE TABLE IF NOT EXISTS auth.magic_links (
+    id            UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id       UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    token_hash    VARCHAR(255) NOT NULL UNIQUE,
+    binding_hash  VARCHAR(255) NOT NULL,
+    expires_at    TIMESTAMPTZ NOT NULL,
+    consumed_at   TIMESTAMPTZ,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_magic_links_user ON auth.magic_links(user_id);
+
+-- The second factor completes whichever first factor started the challenge
+ALTER TABLE auth.mfa_challenges
+    ADD COLUMN IF NOT EXISTS first_factor VARCHAR(16) NOT NULL DEFAULT 'pwd';
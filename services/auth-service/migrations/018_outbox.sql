@@ -0,0 +1,16 @@
+-- Events for other services, written in the same transaction as the change
+-- they describe and delivered by a relay until the receiver acknowledges
+-- them. Receivers deduplicate on id, so an event may be sent more than once.
+CREATE TABLE IF NOT EXISTS auth.outbox_events (
+    id               UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    event_type       VARCHAR(64) NOT NULL,
+    payload          JSONB NOT NULL,
+    attempts         INTEGER NOT NULL DEFAULT 0,
+    last_error       TEXT,
+    next_attempt_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    delivered_at     TIMESTAMPTZ,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_events_pending
+    ON auth.outbox_events(next_attempt_at) WHERE delivered_at IS NULL;
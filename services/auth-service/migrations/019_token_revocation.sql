@@ -0,0 +1,15 @@
+-- Access tokens are checked against these through POST /auth/introspect.
+-- revoked_tokens holds single tokens by jti until they would have expired;
+-- tokens_valid_after revokes every access token of a user issued before it.
+-- Tokens of a session also stop being active once the session is revoked.
+CREATE TABLE IF NOT EXISTS auth.revoked_tokens (
+    jti         VARCHAR(64) PRIMARY KEY,
+    user_id     UUID REFERENCES auth.users(id) ON DELETE CASCADE,
+    expires_at  TIMESTAMPTZ NOT NULL,
+    revoked_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires ON auth.revoked_tokens(expires_at);
+
+ALTER TABLE auth.users
+    ADD COLUMN IF NOT EXISTS tokens_valid_after TIMESTAMPTZ;
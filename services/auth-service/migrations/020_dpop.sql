@@ -0,0 +1,4 @@
+-- Thumbprint of the DPoP key a session's tokens are bound to. Refreshing a
+-- bound session needs a proof signed by the same key.
+ALTER TABLE auth.refresh_tokens
+    ADD COLUMN IF NOT EXISTS dpop_jkt VARCHAR(64);
@@ -0,0 +1,5 @@
+-- Admins disable accounts through /admin/users/:id/disable. Disabling
+-- ends every session and revokes access tokens through tokens_valid_after;
+-- the user can't sign in again until an admin enables the account.
+ALTER TABLE auth.users
+    ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMPTZ;
@@ -0,0 +1,30 @@
+-- Closing an account (LGPD) must erase the personal data in its audit
+-- events: emails in details, IP addresses and user agents. Rows still
+-- can't be deleted, and the only update allowed blanks those three columns
+-- and leaves everything else as it was.
+CREATE OR REPLACE FUNCTION auth.audit_events_redact_only() RETURNS trigger AS $$
+BEGIN
+    IF NEW.id = OLD.id
+       AND NEW.user_id IS NOT DISTINCT FROM OLD.user_id
+       AND NEW.actor_id IS NOT DISTINCT FROM OLD.actor_id
+       AND NEW.event_type = OLD.event_type
+       AND NEW.outcome = OLD.outcome
+       AND NEW.created_at = OLD.created_at
+       AND NEW.details = '{}'::jsonb
+       AND NEW.ip_address = ''
+       AND NEW.user_agent = '' THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'auth.audit_events is append-only, rows can only be redacted';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON auth.audit_events;
+CREATE TRIGGER audit_events_append_only
+    BEFORE DELETE OR TRUNCATE ON auth.audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION auth.audit_events_append_only();
+
+DROP TRIGGER IF EXISTS audit_events_redact_only ON auth.audit_events;
+CREATE TRIGGER audit_events_redact_only
+    BEFORE UPDATE ON auth.audit_events
+    FOR EACH ROW EXECUTE FUNCTION auth.audit_events_redact_only();
@@ -0,0 +1,11 @@
+-- Events the receiver rejects for good (a 4xx response) are not retried.
+-- They keep failed_at and last_error until an operator lists them with
+-- `auth-service outbox failed` and requeues them with `outbox retry`.
+ALTER TABLE auth.outbox_events
+    ADD COLUMN IF NOT EXISTS failed_at TIMESTAMPTZ;
+
+DROP INDEX IF EXISTS auth.idx_outbox_events_pending;
+CREATE INDEX IF NOT EXISTS idx_outbox_events_pending
+    ON auth.outbox_events(next_attempt_at) WHERE delivered_at IS NULL AND failed_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_outbox_events_failed
+    ON auth.outbox_events(failed_at) WHERE failed_at IS NOT NULL;
@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dogpay/payment-service/internal/handlers"
 	"github.com/dogpay/payment-service/internal/middleware"
@@ -56,11 +59,14 @@ func main() {
 	defer mq.Close()
 	log.Println("Connected to RabbitMQ")
 
-	jwtSecret := getEnv("PAYMENT_JWT_SECRET", "dev-secret")
+	keySet := middleware.NewKeySet(
+		getEnv("PAYMENT_JWKS_URL", "http://auth-service:8001/.well-known/jwks.json"),
+		5*time.Minute,
+	)
 
 	// Setup dependencies
 	paymentRepo := repository.NewPaymentRepository(db)
-	paymentHandler := handlers.NewPaymentHandler(paymentRepo, mq)
+	paymentHandler := handlers.NewPaymentHandler(paymentRepo, mq, stepUpPolicy())
 
 	// Start queue consumer
 	go startConsumer(mq, paymentRepo)
@@ -72,8 +78,8 @@ func main() {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:5173", "http://localhost:80"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "DPoP"},
+		ExposeHeaders:    []string{"Content-Length", "DPoP-Nonce", "WWW-Authenticate"},
 		AllowCredentials: true,
 	}))
 
@@ -82,14 +88,29 @@ func main() {
 		c.JSON(200, gin.H{"status": "ok", "service": "payment-service"})
 	})
 
-	// Internal endpoint (called by auth service)
-	r.POST("/internal/accounts", paymentHandler.CreateAccount)
+	// Internal endpoints, called by auth service with a client-credentials token
+	internal := r.Group("/internal", middleware.ServiceAuth(keySet, getEnv("PAYMENT_AUDIENCE", "payment-service"), "payments:internal"))
+	{
+		internal.POST("/events", paymentHandler.HandleEvent)
+		internal.POST("/accounts/:user_id/close", paymentHandler.CloseAccount)
+		internal.GET("/accounts/:user_id/export", paymentHandler.ExportAccount)
+	}
+
+	// Staff access to customer accounts
+	// DPoP proofs name the public URL of the request
+	dpop := middleware.NewDPoPVerifier(getEnv("PAYMENT_PUBLIC_URL", "http://localhost:8002"))
+	jwtAuth := middleware.JWTAuth(keySet, paymentRepo, dpop)
+
+	admin := r.Group("/admin", jwtAuth)
+	{
+		admin.GET("/accounts/:user_id", middleware.RequirePermission("accounts:read"), paymentHandler.ExportAccount)
+	}
 
-	payments := r.Group("/payments", middleware.JWTAuth(jwtSecret))
+	payments := r.Group("/payments", jwtAuth)
 	{
-		payments.GET("/balance", paymentHandler.GetBalance)
-		payments.POST("/transfer", paymentHandler.Transfer)
-		payments.GET("/history", paymentHandler.GetHistory)
+		payments.GET("/balance", middleware.RequireScope("balance:read"), paymentHandler.GetBalance)
+		payments.POST("/transfer", middleware.RequireScope("transfer:write"), middleware.RequirePermission("payments:transfer"), middleware.RequireActiveToken(introspector()), paymentHandler.Transfer)
+		payments.GET("/history", middleware.RequireScope("history:read"), paymentHandler.GetHistory)
 	}
 
 	port := getEnv("PAYMENT_PORT", "8002")
@@ -135,6 +156,50 @@ func startConsumer(mq *queue.RabbitMQ, repo *repository.PaymentRepository) {
 	}
 }
 
+// stepUpPolicy reads the step-up settings: transfers above
+// PAYMENT_STEP_UP_THRESHOLD need a login within PAYMENT_STEP_UP_MAX_AGE
+// using one of PAYMENT_STEP_UP_METHODS.
+func stepUpPolicy() middleware.StepUpPolicy {
+	threshold, err := strconv.ParseFloat(getEnv("PAYMENT_STEP_UP_THRESHOLD", "1000"), 64)
+	if err != nil {
+		log.Fatalf("invalid PAYMENT_STEP_UP_THRESHOLD: %v", err)
+	}
+
+	maxAge, err := time.ParseDuration(getEnv("PAYMENT_STEP_UP_MAX_AGE", "5m"))
+	if err != nil {
+		log.Fatalf("invalid PAYMENT_STEP_UP_MAX_AGE: %v", err)
+	}
+
+	return middleware.StepUpPolicy{
+		Threshold: threshold,
+		MaxAge:    maxAge,
+		Methods:   strings.Split(getEnv("PAYMENT_STEP_UP_METHODS", "pwd,otp"), ","),
+	}
+}
+
+// introspector checks sensitive routes against auth-service's revocation
+// list when PAYMENT_INTROSPECTION_CLIENT_ID is set, caching answers for
+// PAYMENT_INTROSPECTION_CACHE_TTL. Without it tokens are trusted until they
+// expire.
+func introspector() *middleware.Introspector {
+	clientID := os.Getenv("PAYMENT_INTROSPECTION_CLIENT_ID")
+	if clientID == "" {
+		return nil
+	}
+
+	ttl, err := time.ParseDuration(getEnv("PAYMENT_INTROSPECTION_CACHE_TTL", "10s"))
+	if err != nil {
+		log.Fatalf("invalid PAYMENT_INTROSPECTION_CACHE_TTL: %v", err)
+	}
+
+	return middleware.NewIntrospector(
+		getEnv("PAYMENT_INTROSPECTION_URL", "http://auth-service:8001/auth/introspect"),
+		clientID,
+		os.Getenv("PAYMENT_INTROSPECTION_CLIENT_SECRET"),
+		ttl,
+	)
+}
+
 func getEnv(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -1,50 +1,150 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"time"
 
+	"github.com/dogpay/payment-service/internal/middleware"
 	"github.com/dogpay/payment-service/internal/models"
 	"github.com/dogpay/payment-service/internal/queue"
 	"github.com/dogpay/payment-service/internal/repository"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 type PaymentHandler struct {
-	repo *repository.PaymentRepository
-	mq   *queue.RabbitMQ
+	repo   *repository.PaymentRepository
+	mq     *queue.RabbitMQ
+	stepUp middleware.StepUpPolicy
 }
 
-func NewPaymentHandler(repo *repository.PaymentRepository, mq *queue.RabbitMQ) *PaymentHandler {
-	return &PaymentHandler{repo: repo, mq: mq}
+func NewPaymentHandler(repo *repository.PaymentRepository, mq *queue.RabbitMQ, stepUp middleware.StepUpPolicy) *PaymentHandler {
+	return &PaymentHandler{repo: repo, mq: mq, stepUp: stepUp}
 }
 
-func (h *PaymentHandler) CreateAccount(c *gin.Context) {
-	var req models.CreateAccountRequest
+// HandleEvent processes an event from auth service's outbox. Auth service
+// keeps resending an event until it gets a 2xx, so events that were already
+// processed are acknowledged without being applied again.
+func (h *PaymentHandler) HandleEvent(c *gin.Context) {
+	var event models.Event
+	if err := c.ShouldBindJSON(&event); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch event.Type {
+	case models.EventUserRegistered:
+		var payload models.UserRegisteredPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid event payload"})
+			return
+		}
+		if err := binding.Validator.ValidateStruct(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		applied, err := h.repo.CreateAccountForEvent(c.Request.Context(), event.ID, payload.UserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create account"})
+			return
+		}
+		if !applied {
+			log.Printf("event %s already processed", event.ID)
+		}
+		c.Status(http.StatusNoContent)
+	default:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "unsupported event type"})
+	}
+}
+
+// CloseAccount is called by auth service when a user closes their account.
+func (h *PaymentHandler) CloseAccount(c *gin.Context) {
+	var req models.CloseAccountRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	account, err := h.repo.CreateAccount(c.Request.Context(), req.UserID)
+	userID := c.Param("user_id")
+
+	var sweepTo string
+	if req.SweepToEmail != "" {
+		target, err := h.repo.GetAccountByEmail(c.Request.Context(), req.SweepToEmail)
+		if err != nil || target.UserID == userID {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "sweep recipient not found"})
+			return
+		}
+		sweepTo = target.ID
+
+		// The request comes from auth service, so the user's login is
+		// taken from the body instead of a token
+		if account, err := h.repo.GetAccountByUserID(c.Request.Context(), userID); err == nil {
+			if req.AuthTime > 0 {
+				c.Set("auth_time", time.Unix(req.AuthTime, 0))
+			}
+			c.Set("amr", req.AMR)
+			if !h.stepUp.Allow(c, account.Balance) {
+				return
+			}
+		}
+	}
+
+	account, err := h.repo.CloseAccount(c.Request.Context(), userID, sweepTo)
+	switch {
+	case errors.Is(err, repository.ErrAccountNotFound):
+		// Users who never opened the app have no account to close
+		c.Status(http.StatusNoContent)
+		return
+	case errors.Is(err, repository.ErrBalanceNotZero):
+		c.JSON(http.StatusConflict, gin.H{"error": "balance must be zero or swept to another account"})
+		return
+	case errors.Is(err, repository.ErrPendingTransactions):
+		c.JSON(http.StatusConflict, gin.H{"error": "account has pending transactions"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to close account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, account)
+}
+
+// ExportAccount returns the account and full transaction history of a
+// user for auth service's data export.
+func (h *PaymentHandler) ExportAccount(c *gin.Context) {
+	export := models.AccountExport{Transactions: []models.Transaction{}}
+
+	account, err := h.repo.GetAccountByUserID(c.Request.Context(), c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusOK, export)
+		return
+	}
+	export.Account = account
+
+	txs, err := h.repo.ExportTransactions(c.Request.Context(), account.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create account"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export transactions"})
 		return
 	}
+	if txs != nil {
+		export.Transactions = txs
+	}
 
-	c.JSON(http.StatusCreated, account)
+	c.JSON(http.StatusOK, export)
 }
 
 func (h *PaymentHandler) GetBalance(c *gin.Context) {
 	userID := c.GetString("user_id")
 
+	// Accounts are opened by the user.registered event, shortly after sign-up
 	account, err := h.repo.GetAccountByUserID(c.Request.Context(), userID)
 	if err != nil {
-		// Auto-create account if it doesn't exist
-		account, err = h.repo.CreateAccount(c.Request.Context(), userID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get balance"})
-			return
-		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -57,18 +157,31 @@ func (h *PaymentHandler) GetBalance(c *gin.Context) {
 func (h *PaymentHandler) Transfer(c *gin.Context) {
 	userID := c.GetString("user_id")
 
+	if !c.GetBool("email_verified") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "email not verified"})
+		return
+	}
+
 	var req models.TransferRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	if !h.stepUp.Allow(c, req.Amount) {
+		return
+	}
+
 	// Get sender account
 	fromAccount, err := h.repo.GetAccountByUserID(c.Request.Context(), userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "sender account not found"})
 		return
 	}
+	if fromAccount.ClosedAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account closed"})
+		return
+	}
 
 	// Get recipient account by email
 	toAccount, err := h.repo.GetAccountByEmail(c.Request.Context(), req.ToEmail)
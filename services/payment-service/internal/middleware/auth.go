@@ -1,20 +1,53 @@
 package middleware
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
+	"slices"
 	"strings"
 
+	"github.com/dogpay/payment-service/internal/models"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type Claims struct {
-	UserID string `json:"user_id"`
-	Email  string `json:"email"`
+	UserID        string           `json:"user_id"`
+	Email         string           `json:"email"`
+	EmailVerified bool             `json:"email_verified"`
+	AuthTime      *jwt.NumericDate `json:"auth_time,omitempty"`
+	AMR           []string         `json:"amr,omitempty"`
+	ACR           string           `json:"acr,omitempty"`
+	Roles         []string         `json:"roles,omitempty"`
+	Permissions   []string         `json:"perms,omitempty"`
+	ClientID      string           `json:"client_id,omitempty"`
+	Scope         string           `json:"scope,omitempty"`
+	Confirmation  *Confirmation    `json:"cnf,omitempty"`
+	TokenUse      string           `json:"token_use,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func JWTAuth(jwtSecret string) gin.HandlerFunc {
+// tokenUseAccess marks access tokens in the token_use claim. Refresh tokens
+// are signed with the same keys and must not authenticate requests.
+const tokenUseAccess = "access"
+
+// personalAccessTokenPrefix marks tokens issued by auth-service's
+// /auth/tokens instead of JWTs.
+const personalAccessTokenPrefix = "dogpay_pat_"
+
+// TokenLookup resolves personal access tokens by their SHA-256 hash.
+type TokenLookup interface {
+	FindPersonalAccessToken(ctx context.Context, tokenHash string) (*models.PersonalAccessToken, error)
+}
+
+// JWTAuth accepts access tokens signed by auth-service, with a DPoP proof
+// when they are DPoP-bound, and personal access tokens. Personal access
+// tokens carry no roles, and of the user's permissions only those their
+// scopes need; RequireScope decides which routes they reach.
+func JWTAuth(keySet *KeySet, pats TokenLookup, dpop *DPoPVerifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -23,26 +56,111 @@ func JWTAuth(jwtSecret string) gin.HandlerFunc {
 		}
 
 		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || parts[0] != "Bearer" {
+		if len(parts) != 2 || (parts[0] != "Bearer" && parts[0] != "DPoP") {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid authorization format"})
 			return
 		}
 
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(parts[1], claims, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
+		if parts[0] == "Bearer" && strings.HasPrefix(parts[1], personalAccessTokenPrefix) {
+			sum := sha256.Sum256([]byte(parts[1]))
+			pat, err := pats.FindPersonalAccessToken(c.Request.Context(), hex.EncodeToString(sum[:]))
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+				return
 			}
-			return []byte(jwtSecret), nil
-		})
 
-		if err != nil || !token.Valid {
+			c.Set("user_id", pat.UserID)
+			c.Set("email", pat.Email)
+			c.Set("email_verified", pat.EmailVerified)
+			c.Set("scopes", pat.Scopes)
+			c.Set("permissions", scopedPermissions(pat.Permissions, pat.Scopes))
+			c.Set("scoped_token", true)
+			c.Next()
+			return
+		}
+
+		claims, err := parseToken(c, keySet, parts[1])
+		if err != nil || claims.TokenUse != tokenUseAccess {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+			return
+		}
+		// Service tokens have no user and only reach the internal routes
+		if claims.UserID == "" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
 			return
 		}
+		if !checkDPoP(c, dpop, parts[0], parts[1], claims) {
+			return
+		}
 
 		c.Set("user_id", claims.UserID)
 		c.Set("email", claims.Email)
+		c.Set("email_verified", claims.EmailVerified)
+		c.Set("roles", claims.Roles)
+		c.Set("permissions", claims.Permissions)
+		if claims.ClientID != "" {
+			// Tokens of third-party OAuth clients are limited like personal
+			// access tokens to the scopes the user consented to
+			c.Set("client_id", claims.ClientID)
+			c.Set("scopes", strings.Fields(claims.Scope))
+			c.Set("permissions", scopedPermissions(claims.Permissions, strings.Fields(claims.Scope)))
+			c.Set("scoped_token", true)
+		}
+		c.Set("amr", claims.AMR)
+		if claims.AuthTime != nil {
+			c.Set("auth_time", claims.AuthTime.Time)
+		}
 		c.Next()
 	}
 }
+
+// ServiceAuth accepts client-credentials tokens that auth-service issued to
+// service clients for audience, carrying scope. Internal routes use it so
+// only registered services can call them.
+func ServiceAuth(keySet *KeySet, audience, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authorization header required"})
+			return
+		}
+
+		claims, err := parseToken(c, keySet, raw, jwt.WithAudience(audience))
+		if err != nil || claims.TokenUse != tokenUseAccess || claims.ClientID == "" || claims.UserID != "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired service token"})
+			return
+		}
+		if !slices.Contains(strings.Fields(claims.Scope), scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "insufficient scope",
+				"scope": scope,
+			})
+			return
+		}
+
+		c.Set("client_id", claims.ClientID)
+		c.Next()
+	}
+}
+
+func parseToken(c *gin.Context, keySet *KeySet, raw string, opts ...jwt.ParserOption) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodEd25519); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		kid, _ := t.Header["kid"].(string)
+		key, ok := keySet.Key(c.Request.Context(), kid)
+		if !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return key, nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dogpay/payment-service/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestJWTAuthRejectsRefreshTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwks{Keys: []jwk{{
+			Kty: "OKP", Crv: "Ed25519", Kid: "test", Alg: "EdDSA",
+			X: base64.RawURLEncoding.EncodeToString(pub),
+		}}})
+	}))
+	defer jwksServer.Close()
+
+	r := gin.New()
+	dpop := NewDPoPVerifier("http://payments.test")
+	r.GET("/payments/balance", JWTAuth(NewKeySet(jwksServer.URL, time.Minute), nil, dpop), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	sign := func(tokenUse string, authTime bool) string {
+		claims := &Claims{
+			UserID:   "4b0c7b4e-2f5c-4e0e-9a57-1f3f8f0c2d11",
+			Email:    "rex@dogpay.test",
+			TokenUse: tokenUse,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:        "jti",
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * 24 * time.Hour)),
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+			},
+		}
+		if authTime {
+			claims.AuthTime = jwt.NewNumericDate(time.Now())
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+		token.Header["kid"] = "test"
+		raw, err := token.SignedString(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"access token", sign("access", true), http.StatusOK},
+		{"refresh token", sign("refresh", false), http.StatusUnauthorized},
+		{"refresh token issued before token_use", sign("", false), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/payments/balance", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
+
+type patLookup struct {
+	pat *models.PersonalAccessToken
+}
+
+func (l patLookup) FindPersonalAccessToken(ctx context.Context, tokenHash string) (*models.PersonalAccessToken, error) {
+	return l.pat, nil
+}
+
+func TestPersonalAccessTokensGetOnlyScopePermissions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	pats := patLookup{&models.PersonalAccessToken{
+		UserID:      "4b0c7b4e-2f5c-4e0e-9a57-1f3f8f0c2d11",
+		Email:       "carol@dogpay.test",
+		Scopes:      []string{"transfer:write"},
+		Permissions: []string{"accounts:read", "payments:transfer"},
+	}}
+	r := gin.New()
+	auth := JWTAuth(nil, pats, nil)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.POST("/payments/transfer", auth, RequireScope("transfer:write"), RequirePermission("payments:transfer"), ok)
+	r.GET("/admin/accounts/:user_id", auth, RequirePermission("accounts:read"), ok)
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodPost, "/payments/transfer", http.StatusOK},
+		{http.MethodGet, "/admin/accounts/4b0c7b4e-2f5c-4e0e-9a57-1f3f8f0c2d11", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+personalAccessTokenPrefix+"secret")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
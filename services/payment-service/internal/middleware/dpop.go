@@ -0,0 +1,229 @@
+package middleware
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Confirmation is the cnf claim of access tokens auth-service bound to the
+// key of a DPoP proof, by its RFC 7638 thumbprint.
+type Confirmation struct {
+	JKT string `json:"jkt"`
+}
+
+const (
+	// Nonces rotate this often; the previous one is still accepted
+	dpopNonceLifetime = 5 * time.Minute
+	dpopProofMaxAge   = 5 * time.Minute
+	dpopClockSkew     = 30 * time.Second
+)
+
+// ErrDPoPNonce means the proof lacked the current nonce. The client retries
+// with the one in the DPoP-Nonce response header.
+var ErrDPoPNonce = errors.New("dpop proof must carry the current nonce")
+
+// DPoPVerifier checks RFC 9449 DPoP proofs sent to this service, whose
+// public URL is baseURL. Nonces are this service's own, separate from
+// auth-service's. It hands out the nonces proofs must carry and
+// remembers proof IDs so a proof can't be replayed.
+type DPoPVerifier struct {
+	baseURL string
+
+	mu            sync.Mutex
+	nonce         string
+	previousNonce string
+	rotatedAt     time.Time
+	seen          map[string]time.Time
+}
+
+type dpopClaims struct {
+	HTM   string `json:"htm"`
+	HTU   string `json:"htu"`
+	Nonce string `json:"nonce"`
+	ATH   string `json:"ath,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func NewDPoPVerifier(baseURL string) *DPoPVerifier {
+	return &DPoPVerifier{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		seen:    map[string]time.Time{},
+	}
+}
+
+// Nonce returns the nonce proofs have to carry right now.
+func (v *DPoPVerifier) Nonce() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.rotate()
+	return v.nonce
+}
+
+// rotate replaces the nonce once it is older than dpopNonceLifetime and
+// forgets proof IDs that are too old to be accepted anyway. v.mu is held.
+func (v *DPoPVerifier) rotate() {
+	if v.nonce != "" && time.Since(v.rotatedAt) < dpopNonceLifetime {
+		return
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	v.previousNonce, v.nonce = v.nonce, base64.RawURLEncoding.EncodeToString(b)
+	v.rotatedAt = time.Now()
+
+	for id, expires := range v.seen {
+		if time.Now().After(expires) {
+			delete(v.seen, id)
+		}
+	}
+}
+
+// Verify checks a proof for a request with method to path and returns the
+// thumbprint of the key that signed it. A proof sent with an access token
+// must carry the token's hash.
+func (v *DPoPVerifier) Verify(proof, method, path, accessToken string) (string, error) {
+	var jkt string
+	claims := &dpopClaims{}
+	_, err := jwt.ParseWithClaims(proof, claims, func(t *jwt.Token) (interface{}, error) {
+		if typ, _ := t.Header["typ"].(string); typ != "dpop+jwt" {
+			return nil, errors.New("typ must be dpop+jwt")
+		}
+		jwk, _ := t.Header["jwk"].(map[string]interface{})
+		key, thumbprint, err := dpopKey(jwk)
+		if err != nil {
+			return nil, err
+		}
+		jkt = thumbprint
+		return key, nil
+	}, jwt.WithValidMethods([]string{"ES256", "EdDSA"}))
+	if err != nil {
+		return "", fmt.Errorf("invalid dpop proof: %w", err)
+	}
+
+	htu, _, _ := strings.Cut(claims.HTU, "?")
+	htu, _, _ = strings.Cut(htu, "#")
+	switch {
+	case claims.ID == "" || claims.IssuedAt == nil:
+		return "", errors.New("dpop proof must have jti and iat")
+	case claims.HTM != method || htu != v.baseURL+path:
+		return "", errors.New("dpop proof was made for another request")
+	case time.Since(claims.IssuedAt.Time) > dpopProofMaxAge || time.Until(claims.IssuedAt.Time) > dpopClockSkew:
+		return "", errors.New("dpop proof is too old")
+	}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if claims.ATH != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			return "", errors.New("dpop proof was made for another access token")
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.rotate()
+	if claims.Nonce == "" || (claims.Nonce != v.nonce && claims.Nonce != v.previousNonce) {
+		return "", ErrDPoPNonce
+	}
+	id := jkt + ":" + claims.ID
+	if _, replayed := v.seen[id]; replayed {
+		return "", errors.New("dpop proof was already used")
+	}
+	v.seen[id] = claims.IssuedAt.Add(dpopProofMaxAge + dpopClockSkew)
+	return jkt, nil
+}
+
+// dpopKey reads the public key from a proof's jwk header and computes its
+// thumbprint. P-256 and Ed25519 keys are supported.
+func dpopKey(jwk map[string]interface{}) (interface{}, string, error) {
+	str := func(name string) string {
+		s, _ := jwk[name].(string)
+		return s
+	}
+	if jwk == nil {
+		return nil, "", errors.New("jwk header is required")
+	}
+	if _, private := jwk["d"]; private {
+		return nil, "", errors.New("jwk must not contain a private key")
+	}
+
+	var key interface{}
+	var canonical string
+	switch {
+	case str("kty") == "EC" && str("crv") == "P-256":
+		x, errX := base64.RawURLEncoding.DecodeString(str("x"))
+		y, errY := base64.RawURLEncoding.DecodeString(str("y"))
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, "", errors.New("invalid P-256 key")
+		}
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, "", errors.New("invalid P-256 key")
+		}
+		key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		canonical = fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`, str("x"), str("y"))
+	case str("kty") == "OKP" && str("crv") == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(str("x"))
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, "", errors.New("invalid Ed25519 key")
+		}
+		key = ed25519.PublicKey(x)
+		canonical = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`, str("x"))
+	default:
+		return nil, "", errors.New("unsupported jwk")
+	}
+
+	sum := sha256.Sum256([]byte(canonical))
+	return key, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// checkDPoP enforces the binding of an access token presented with scheme.
+// DPoP-bound tokens need the DPoP scheme and a proof from their key; other
+// tokens must use Bearer.
+func checkDPoP(c *gin.Context, v *DPoPVerifier, scheme, raw string, claims *Claims) bool {
+	bound := claims.Confirmation != nil && claims.Confirmation.JKT != ""
+	if !bound {
+		if scheme != "Bearer" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token is not DPoP-bound"})
+			return false
+		}
+		return true
+	}
+
+	c.Header("DPoP-Nonce", v.Nonce())
+	proofs := c.Request.Header.Values("DPoP")
+	if scheme != "DPoP" || len(proofs) != 1 {
+		c.Header("WWW-Authenticate", `DPoP error="invalid_token", algs="ES256 EdDSA"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "dpop proof required for this token"})
+		return false
+	}
+
+	jkt, err := v.Verify(proofs[0], c.Request.Method, c.Request.URL.Path, raw)
+	if errors.Is(err, ErrDPoPNonce) {
+		c.Header("WWW-Authenticate", `DPoP error="use_dpop_nonce", algs="ES256 EdDSA"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "use_dpop_nonce"})
+		return false
+	}
+	if err == nil && jkt != claims.Confirmation.JKT {
+		err = errors.New("dpop proof was signed by another key")
+	}
+	if err != nil {
+		c.Header("WWW-Authenticate", `DPoP error="invalid_dpop_proof", algs="ES256 EdDSA"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_dpop_proof", "error_description": err.Error()})
+		return false
+	}
+
+	c.Set("dpop_jkt", jkt)
+	return true
+}
@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Introspector asks auth-service's introspection endpoint whether access
+// tokens were revoked. Answers are cached for the TTL, which bounds how long
+// a revoked token keeps working on the routes that check it.
+type Introspector struct {
+	url          string
+	clientID     string
+	clientSecret string
+	ttl          time.Duration
+	client       *http.Client
+
+	mu    sync.Mutex
+	cache map[string]introspection
+}
+
+type introspection struct {
+	active    bool
+	checkedAt time.Time
+}
+
+// The cache is swept of stale answers once it grows past this size.
+const introspectionCacheSweep = 10000
+
+func NewIntrospector(url, clientID, clientSecret string, ttl time.Duration) *Introspector {
+	return &Introspector{
+		url:          url,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		ttl:          ttl,
+		client:       &http.Client{Timeout: 5 * time.Second},
+		cache:        map[string]introspection{},
+	}
+}
+
+// Active reports whether auth-service still considers token active.
+func (i *Introspector) Active(ctx context.Context, token string) (bool, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+
+	i.mu.Lock()
+	cached, ok := i.cache[key]
+	i.mu.Unlock()
+	if ok && time.Since(cached.checkedAt) < i.ttl {
+		return cached.active, nil
+	}
+
+	active, err := i.introspect(ctx, token)
+	if err != nil {
+		return false, err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if len(i.cache) >= introspectionCacheSweep {
+		for k, v := range i.cache {
+			if time.Since(v.checkedAt) >= i.ttl {
+				delete(i.cache, k)
+			}
+		}
+	}
+	i.cache[key] = introspection{active: active, checkedAt: time.Now()}
+	return active, nil
+}
+
+func (i *Introspector) introspect(ctx context.Context, token string) (bool, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(i.clientID), url.QueryEscape(i.clientSecret))
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("introspect token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("introspect token: auth-service responded %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Active bool `json:"active"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("decode introspection response: %w", err)
+	}
+	return result.Active, nil
+}
+
+// RequireActiveToken rejects access tokens that were revoked before they
+// expired, e.g. by a logout or a password reset. It runs after JWTAuth.
+// Personal access tokens are looked up on every request anyway and are let
+// through. With a nil introspector the check is off.
+func RequireActiveToken(i *Introspector) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, raw, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+		if i == nil || strings.HasPrefix(raw, personalAccessTokenPrefix) {
+			c.Next()
+			return
+		}
+
+		active, err := i.Active(c.Request.Context(), raw)
+		if err != nil {
+			// Failing closed: these are the routes where a stolen token hurts
+			log.Printf("token introspection unavailable: %v", err)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "unable to verify token"})
+			return
+		}
+		if !active {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token has been revoked"})
+			return
+		}
+		c.Next()
+	}
+}
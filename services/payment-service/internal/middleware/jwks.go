@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// KeySet caches the verification keys auth-service publishes at its JWKS
+// endpoint, indexed by kid. Keys are refetched once they are older than the
+// TTL; a failed fetch keeps serving the previous keys so an auth-service blip
+// does not reject every request.
+type KeySet struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]ed25519.PublicKey
+	fetchedAt time.Time
+	failedAt  time.Time
+	// inflight is closed when the running fetch finishes; concurrent
+	// refreshes wait for it instead of fetching again
+	inflight chan struct{}
+}
+
+// Refreshes forced by an unknown kid are limited to one per interval, so
+// tokens with made-up kids cannot hammer auth-service. After a failed fetch
+// no refresh is tried for the same interval.
+const forcedRefreshInterval = 30 * time.Second
+
+func NewKeySet(url string, ttl time.Duration) *KeySet {
+	return &KeySet{
+		url:    url,
+		ttl:    ttl,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+// Key returns the verification key for kid. A kid missing from the cache
+// triggers a refetch, which is how keys rotated in by auth-service show up
+// before the TTL expires.
+func (s *KeySet) Key(ctx context.Context, kid string) (ed25519.PublicKey, bool) {
+	s.mu.Lock()
+	keys, fresh := s.keys, time.Since(s.fetchedAt) < s.ttl
+	s.mu.Unlock()
+
+	if !fresh {
+		keys = s.refresh(ctx, s.ttl)
+	}
+	if key, ok := keys[kid]; ok {
+		return key, true
+	}
+
+	key, ok := s.refresh(ctx, forcedRefreshInterval)[kid]
+	return key, ok
+}
+
+// refresh fetches the keys unless they are younger than minAge or a fetch
+// failed recently. The fetch runs without holding the lock, once for all
+// concurrent callers.
+func (s *KeySet) refresh(ctx context.Context, minAge time.Duration) map[string]ed25519.PublicKey {
+	s.mu.Lock()
+	if time.Since(s.fetchedAt) < minAge || time.Since(s.failedAt) < forcedRefreshInterval {
+		defer s.mu.Unlock()
+		return s.keys
+	}
+	if wait := s.inflight; wait != nil {
+		s.mu.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.keys
+	}
+	done := make(chan struct{})
+	s.inflight = done
+	s.mu.Unlock()
+
+	// Other requests wait on this fetch, so it must outlive this request
+	keys, err := s.fetch(context.WithoutCancel(ctx))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inflight = nil
+	close(done)
+	if err != nil {
+		log.Printf("failed to fetch JWKS from %s: %v", s.url, err)
+		s.failedAt = time.Now()
+		return s.keys
+	}
+	s.keys = keys
+	s.fetchedAt = time.Now()
+	return s.keys
+}
+
+func (s *KeySet) fetch(ctx context.Context) (map[string]ed25519.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var set jwks
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]ed25519.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "OKP" || k.Crv != "Ed25519" || k.Kid == "" {
+			continue
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			continue
+		}
+		keys[k.Kid] = ed25519.PublicKey(x)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no usable Ed25519 keys")
+	}
+	return keys, nil
+}
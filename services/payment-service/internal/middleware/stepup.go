@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StepUpPolicy decides which operations need a recent, strong login.
+// Amounts above Threshold require a token whose auth_time is at most MaxAge
+// old and whose amr contains one of Methods.
+type StepUpPolicy struct {
+	Threshold float64
+	MaxAge    time.Duration
+	Methods   []string
+}
+
+// Allow reports whether the request may move amount. Otherwise it writes a
+// step_up_required error telling the client how to re-authenticate via
+// auth-service's /auth/step-up.
+func (p StepUpPolicy) Allow(c *gin.Context, amount float64) bool {
+	if amount <= p.Threshold || p.satisfied(c) {
+		return true
+	}
+
+	maxAge := int(p.MaxAge.Seconds())
+	c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_user_authentication", max_age=%d`, maxAge))
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"error":     "step_up_required",
+		"message":   "re-authenticate to transfer this amount",
+		"threshold": p.Threshold,
+		"max_age":   maxAge,
+		"methods":   p.Methods,
+	})
+	return false
+}
+
+func (p StepUpPolicy) satisfied(c *gin.Context) bool {
+	authTime := c.GetTime("auth_time")
+	if authTime.IsZero() || time.Since(authTime) > p.MaxAge {
+		return false
+	}
+
+	for _, used := range c.GetStringSlice("amr") {
+		for _, accepted := range p.Methods {
+			if used == accepted {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -1,19 +1,26 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Account struct {
 	ID        string    `json:"id" db:"id"`
 	UserID    string    `json:"user_id" db:"user_id"`
-	Balance   float64   `json:"balance" db:"balance"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	Balance   float64    `json:"balance" db:"balance"`
+	ClosedAt  *time.Time `json:"closed_at,omitempty" db:"closed_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
 }
 
 type Transaction struct {
 	ID              string     `json:"id" db:"id"`
 	FromAccountID   *string    `json:"from_account_id" db:"from_account_id"`
 	ToAccountID     string     `json:"to_account_id" db:"to_account_id"`
+	// Current names of both parties, read from auth.users
+	FromName        *string    `json:"from_name" db:"from_name"`
+	ToName          string     `json:"to_name" db:"to_name"`
 	Amount          float64    `json:"amount" db:"amount"`
 	Status          string     `json:"status" db:"status"`
 	Description     *string    `json:"description" db:"description"`
@@ -35,6 +42,44 @@ type TransferMessage struct {
 	Amount          float64 `json:"amount"`
 }
 
-type CreateAccountRequest struct {
-	UserID string `json:"user_id" binding:"required"`
+// PersonalAccessToken is a token from auth.personal_access_tokens, joined
+// with its user.
+type PersonalAccessToken struct {
+	UserID        string
+	Email         string
+	EmailVerified bool
+	Scopes        []string
+	// Permissions of the user's roles, current as of the lookup
+	Permissions []string
+}
+
+// EventUserRegistered is sent by auth service for every new user, who
+// gets a payment account.
+const EventUserRegistered = "user.registered"
+
+// Event is an event delivered by auth service from its outbox. The same
+// event may arrive more than once; ID identifies it.
+type Event struct {
+	ID      string          `json:"id" binding:"required,uuid"`
+	Type    string          `json:"type" binding:"required"`
+	Payload json.RawMessage `json:"payload" binding:"required"`
+}
+
+type UserRegisteredPayload struct {
+	UserID string `json:"user_id" binding:"required,uuid"`
+}
+
+// CloseAccountRequest may name an account that receives the remaining
+// balance; without one the balance must already be zero. AuthTime and AMR
+// describe the user's login, which a sweep above the step-up threshold is
+// checked against like a transfer.
+type CloseAccountRequest struct {
+	SweepToEmail string   `json:"sweep_to_email" binding:"omitempty,email"`
+	AuthTime     int64    `json:"auth_time"`
+	AMR          []string `json:"amr"`
+}
+
+type AccountExport struct {
+	Account      *Account      `json:"account"`
+	Transactions []Transaction `json:"transactions"`
 }
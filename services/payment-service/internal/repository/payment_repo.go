@@ -2,12 +2,20 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/dogpay/payment-service/internal/models"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+var (
+	ErrAccountNotFound     = errors.New("account not found")
+	ErrBalanceNotZero      = errors.New("account balance is not zero")
+	ErrPendingTransactions = errors.New("account has pending transactions")
+)
+
 type PaymentRepository struct {
 	db *pgxpool.Pool
 }
@@ -16,27 +24,49 @@ func NewPaymentRepository(db *pgxpool.Pool) *PaymentRepository {
 	return &PaymentRepository{db: db}
 }
 
-func (r *PaymentRepository) CreateAccount(ctx context.Context, userID string) (*models.Account, error) {
-	account := &models.Account{}
-	err := r.db.QueryRow(ctx, `
+// CreateAccountForEvent opens the account of a new user once per event.
+// It reports false when the event was already processed.
+func (r *PaymentRepository) CreateAccountForEvent(ctx context.Context, eventID, userID string) (bool, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO payments.processed_events (event_id, event_type)
+		VALUES ($1, $2)
+		ON CONFLICT (event_id) DO NOTHING
+	`, eventID, models.EventUserRegistered)
+	if err != nil {
+		return false, fmt.Errorf("record event: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	_, err = tx.Exec(ctx, `
 		INSERT INTO payments.accounts (user_id, balance)
 		VALUES ($1, 1000.00)
-		ON CONFLICT (user_id) DO UPDATE SET updated_at = NOW()
-		RETURNING id, user_id, balance, created_at, updated_at
-	`, userID).Scan(&account.ID, &account.UserID, &account.Balance, &account.CreatedAt, &account.UpdatedAt)
+		ON CONFLICT (user_id) DO NOTHING
+	`, userID)
 	if err != nil {
-		return nil, fmt.Errorf("create account: %w", err)
+		return false, fmt.Errorf("create account: %w", err)
 	}
-	return account, nil
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("commit transaction: %w", err)
+	}
+	return true, nil
 }
 
 func (r *PaymentRepository) GetAccountByUserID(ctx context.Context, userID string) (*models.Account, error) {
 	account := &models.Account{}
 	err := r.db.QueryRow(ctx, `
-		SELECT id, user_id, balance, created_at, updated_at
+		SELECT id, user_id, balance, closed_at, created_at, updated_at
 		FROM payments.accounts
 		WHERE user_id = $1
-	`, userID).Scan(&account.ID, &account.UserID, &account.Balance, &account.CreatedAt, &account.UpdatedAt)
+	`, userID).Scan(&account.ID, &account.UserID, &account.Balance, &account.ClosedAt, &account.CreatedAt, &account.UpdatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("get account by user_id: %w", err)
 	}
@@ -46,17 +76,40 @@ func (r *PaymentRepository) GetAccountByUserID(ctx context.Context, userID strin
 func (r *PaymentRepository) GetAccountByEmail(ctx context.Context, email string) (*models.Account, error) {
 	account := &models.Account{}
 	err := r.db.QueryRow(ctx, `
-		SELECT pa.id, pa.user_id, pa.balance, pa.created_at, pa.updated_at
+		SELECT pa.id, pa.user_id, pa.balance, pa.closed_at, pa.created_at, pa.updated_at
 		FROM payments.accounts pa
 		JOIN auth.users au ON au.id = pa.user_id
-		WHERE au.email = $1
-	`, email).Scan(&account.ID, &account.UserID, &account.Balance, &account.CreatedAt, &account.UpdatedAt)
+		WHERE au.email = $1 AND pa.closed_at IS NULL
+	`, email).Scan(&account.ID, &account.UserID, &account.Balance, &account.ClosedAt, &account.CreatedAt, &account.UpdatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("get account by email: %w", err)
 	}
 	return account, nil
 }
 
+// FindPersonalAccessToken resolves a personal access token issued by auth
+// service and records that it was used.
+func (r *PaymentRepository) FindPersonalAccessToken(ctx context.Context, tokenHash string) (*models.PersonalAccessToken, error) {
+	pat := &models.PersonalAccessToken{}
+	err := r.db.QueryRow(ctx, `
+		UPDATE auth.personal_access_tokens p SET last_used_at = NOW()
+		FROM auth.users u
+		WHERE p.token_hash = $1 AND u.id = p.user_id
+		  AND (p.expires_at IS NULL OR p.expires_at > NOW()) AND u.closed_at IS NULL AND u.disabled_at IS NULL
+		RETURNING p.user_id, u.email, u.email_verified_at IS NOT NULL, p.scopes,
+		          COALESCE(ARRAY(
+		              SELECT DISTINCT rp.permission
+		              FROM auth.user_roles ur
+		              JOIN auth.role_permissions rp ON rp.role = ur.role
+		              WHERE ur.user_id = p.user_id
+		          ), '{}')
+	`, tokenHash).Scan(&pat.UserID, &pat.Email, &pat.EmailVerified, &pat.Scopes, &pat.Permissions)
+	if err != nil {
+		return nil, fmt.Errorf("find personal access token: %w", err)
+	}
+	return pat, nil
+}
+
 func (r *PaymentRepository) CreatePendingTransaction(ctx context.Context, fromAccountID, toAccountID string, amount float64, description string) (*models.Transaction, error) {
 	tx := &models.Transaction{}
 	err := r.db.QueryRow(ctx, `
@@ -128,13 +181,32 @@ func (r *PaymentRepository) failTransaction(ctx context.Context, transactionID,
 }
 
 func (r *PaymentRepository) GetTransactionHistory(ctx context.Context, accountID string) ([]models.Transaction, error) {
+	limit := 50
+	return r.listTransactions(ctx, accountID, &limit)
+}
+
+// ExportTransactions returns every transaction of an account, for data
+// export requests.
+func (r *PaymentRepository) ExportTransactions(ctx context.Context, accountID string) ([]models.Transaction, error) {
+	return r.listTransactions(ctx, accountID, nil)
+}
+
+// listTransactions returns the newest transactions of an account; a nil
+// limit returns all of them.
+func (r *PaymentRepository) listTransactions(ctx context.Context, accountID string, limit *int) ([]models.Transaction, error) {
+	// Names are joined live, so a renamed user shows up under the new name
 	rows, err := r.db.Query(ctx, `
-		SELECT id, from_account_id, to_account_id, amount, status, description, error_message, created_at, updated_at
-		FROM payments.transactions
-		WHERE from_account_id = $1 OR to_account_id = $1
-		ORDER BY created_at DESC
-		LIMIT 50
-	`, accountID)
+		SELECT t.id, t.from_account_id, t.to_account_id, fu.name, COALESCE(tu.name, ''),
+		       t.amount, t.status, t.description, t.error_message, t.created_at, t.updated_at
+		FROM payments.transactions t
+		LEFT JOIN payments.accounts fa ON fa.id = t.from_account_id
+		LEFT JOIN auth.users fu ON fu.id = fa.user_id
+		LEFT JOIN payments.accounts ta ON ta.id = t.to_account_id
+		LEFT JOIN auth.users tu ON tu.id = ta.user_id
+		WHERE t.from_account_id = $1 OR t.to_account_id = $1
+		ORDER BY t.created_at DESC
+		LIMIT $2
+	`, accountID, limit)
 	if err != nil {
 		return nil, fmt.Errorf("get transaction history: %w", err)
 	}
@@ -144,7 +216,7 @@ func (r *PaymentRepository) GetTransactionHistory(ctx context.Context, accountID
 	for rows.Next() {
 		var tx models.Transaction
 		if err := rows.Scan(
-			&tx.ID, &tx.FromAccountID, &tx.ToAccountID, &tx.Amount, &tx.Status,
+			&tx.ID, &tx.FromAccountID, &tx.ToAccountID, &tx.FromName, &tx.ToName, &tx.Amount, &tx.Status,
 			&tx.Description, &tx.ErrorMessage, &tx.CreatedAt, &tx.UpdatedAt,
 		); err != nil {
 			return nil, err
@@ -153,3 +225,80 @@ func (r *PaymentRepository) GetTransactionHistory(ctx context.Context, accountID
 	}
 	return txs, nil
 }
+
+// CloseAccount closes the account of a user. A remaining balance is moved
+// to sweepToAccountID in a completed transaction, or refused when that is
+// empty. Closing an account twice is a no-op.
+func (r *PaymentRepository) CloseAccount(ctx context.Context, userID, sweepToAccountID string) (*models.Account, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	account := &models.Account{}
+	err = tx.QueryRow(ctx, `
+		SELECT id, user_id, balance, closed_at, created_at, updated_at
+		FROM payments.accounts
+		WHERE user_id = $1
+		FOR UPDATE
+	`, userID).Scan(&account.ID, &account.UserID, &account.Balance, &account.ClosedAt, &account.CreatedAt, &account.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get account by user_id: %w", err)
+	}
+	if account.ClosedAt != nil {
+		return account, nil
+	}
+
+	var pending bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM payments.transactions
+			WHERE (from_account_id = $1 OR to_account_id = $1) AND status = 'pending'
+		)
+	`, account.ID).Scan(&pending)
+	if err != nil {
+		return nil, fmt.Errorf("check pending transactions: %w", err)
+	}
+	if pending {
+		return nil, ErrPendingTransactions
+	}
+
+	if account.Balance > 0 {
+		if sweepToAccountID == "" {
+			return nil, ErrBalanceNotZero
+		}
+
+		_, err = tx.Exec(ctx, `
+			UPDATE payments.accounts SET balance = balance + $1, updated_at = NOW() WHERE id = $2
+		`, account.Balance, sweepToAccountID)
+		if err != nil {
+			return nil, fmt.Errorf("credit sweep account: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO payments.transactions (from_account_id, to_account_id, amount, status, description)
+			VALUES ($1, $2, $3, 'completed', 'Saldo transferido no encerramento da conta')
+		`, account.ID, sweepToAccountID, account.Balance)
+		if err != nil {
+			return nil, fmt.Errorf("record sweep transaction: %w", err)
+		}
+	}
+
+	err = tx.QueryRow(ctx, `
+		UPDATE payments.accounts SET balance = 0, closed_at = NOW(), updated_at = NOW()
+		WHERE id = $1
+		RETURNING balance, closed_at, updated_at
+	`, account.ID).Scan(&account.Balance, &account.ClosedAt, &account.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("close account: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+	return account, nil
+}
@@ -0,0 +1,4 @@
+-- Closed accounts keep their row and transactions, which must be retained,
+-- but can no longer send or receive transfers.
+ALTER TABLE payments.accounts
+    ADD COLUMN IF NOT EXISTS closed_at TIMESTAMPTZ;
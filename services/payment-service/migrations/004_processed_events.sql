@@ -0,0 +1,15 @@
+-- Events from auth service that were already applied. Auth service resends
+-- an event until it is acknowledged, so each one is applied at most once.
+CREATE TABLE IF NOT EXISTS payments.processed_events (
+    event_id      UUID PRIMARY KEY,
+    event_type    VARCHAR(64) NOT NULL,
+    processed_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Users whose account was never created by the old best-effort call, which
+-- until now were only covered by the balance endpoint creating it on read
+INSERT INTO payments.accounts (user_id, balance)
+SELECT u.id, 1000.00
+FROM auth.users u
+WHERE u.closed_at IS NULL
+ON CONFLICT (user_id) DO NOTHING;